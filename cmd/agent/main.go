@@ -8,6 +8,7 @@ import (
 
 	"github.com/xKARASb/Calculator/internal/agent"
 	"github.com/xKARASb/Calculator/internal/config"
+	"github.com/xKARASb/Calculator/pkg/models"
 )
 
 func main() {
@@ -17,6 +18,18 @@ func main() {
 	}
 
 	computingPower := cfg.ComputingPower
+	capabilities := models.Capabilities{Operations: cfg.AgentOperations, Precisions: cfg.AgentPrecisions}
+
+	if cfg.AgentBatch {
+		a := agent.NewAgent(0, cfg.OrchestratorHost)
+		a.Capabilities = capabilities
+		a.Capabilities.Concurrency = computingPower
+		fmt.Println("Started Agent with", computingPower, "workers")
+		if err = a.Serve(); err != nil {
+			log.Println("Agent", a.ID, ":", err)
+		}
+		return
+	}
 
 	var wg sync.WaitGroup
 	for i := 0; i < computingPower; i++ {
@@ -24,6 +37,7 @@ func main() {
 		go func(id int) {
 			defer wg.Done()
 			a := agent.NewAgent(id, cfg.OrchestratorHost)
+			a.Capabilities = capabilities
 			fmt.Println("Started Agent:", id)
 			err := a.CalculateExpression()
 			if err != nil {
@@ -15,6 +15,7 @@ import (
 	"github.com/xKARASb/Calculator/pkg/db/cache"
 	"github.com/xKARASb/Calculator/pkg/db/postgres"
 	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
 )
 
 func main() {
@@ -31,10 +32,11 @@ func main() {
 	redis := cache.New(cfg.RedisConfig)
 	fmt.Println(redis.Ping(ctx))
 
-	repo := repository.NewCalculatorRepository(ctx, db, redis)
+	repo := repository.NewCalculatorRepository(ctx, cfg.CalculatorRepositoryConfig, db, redis)
 	srv := service.NewCalculatorService(repo)
 
 	createAgentUser(repo)
+	createAdminUser(repo, cfg.AdminLogin, cfg.AdminPassword)
 
 	server := servers.NewCalculatorServer(cfg.CalculatorServerConfig, srv)
 
@@ -62,3 +64,21 @@ func createAgentUser(repo *repository.CalculatorRepository) {
 		log.Println("Agent-user has been successfully created or already exists")
 	}
 }
+
+func createAdminUser(repo *repository.CalculatorRepository, login, password string) {
+	if password == "" {
+		return
+	}
+
+	err := repo.Register(login, password)
+	if err != nil && err != errors.ErrUserAlreadyExists {
+		log.Printf("Error with creating an admin-user: %v", err)
+		return
+	}
+
+	if err = repo.SetUserRole(login, roles.RoleAdmin); err != nil {
+		log.Printf("Error with granting the admin role: %v", err)
+		return
+	}
+	log.Println("Admin-user has been successfully created or already exists")
+}
@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	// fetchInterval is how often a full buffer is checked for room.
+	fetchInterval = 50 * time.Millisecond
+	// flushInterval bounds how long a result waits for the other tasks still
+	// running to be reported together with it.
+	flushInterval = 100 * time.Millisecond
+)
+
+// Serve runs as many workers as the agent declared in its capabilities, fed
+// from a local buffer of the same size.
+// Tasks are fetched and results reported in batches, so that the number of
+// requests to the orchestrator does not grow with the number of workers.
+func (a *Agent) Serve() error {
+	concurrency := max(a.Capabilities.Concurrency, 1)
+	tasks := make(chan models.TaskData, concurrency)
+	results := make(chan models.Result, concurrency)
+
+	// busy counts the tasks taken from the orchestrator and not reported yet.
+	var busy atomic.Int64
+
+	if err := a.register(); err != nil {
+		fmt.Printf("Agent %s registration error: %v\n", a.Name, err)
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for task := range tasks {
+				result := compute(task)
+				if !a.work(task) {
+					fmt.Printf("Agent %s abandoned cancelled task %d\n", a.Name, task.ID)
+					busy.Add(-1)
+					continue
+				}
+				results <- result
+			}
+		}()
+	}
+	go a.flush(results, concurrency, &busy)
+
+	for {
+		room := cap(tasks) - len(tasks)
+		if room == 0 {
+			time.Sleep(fetchInterval)
+			continue
+		}
+
+		batch, err := a.getTasks(room)
+		if err != nil || len(batch) == 0 {
+			// Results of running tasks are likely to unlock new ones soon.
+			if busy.Load() > 0 {
+				time.Sleep(fetchInterval)
+			} else {
+				time.Sleep(1 * time.Second)
+			}
+			continue
+		}
+		busy.Add(int64(len(batch)))
+		for _, task := range batch {
+			tasks <- task
+		}
+
+		if a.token == "" {
+			token, err := a.login("agent", "agent_password")
+			if err == nil {
+				a.token = token
+			}
+		}
+	}
+}
+
+// flush reports results once size of them are ready, no other task is
+// running or the oldest one has waited for flushInterval.
+func (a *Agent) flush(results <-chan models.Result, size int, busy *atomic.Int64) {
+	batch := make([]models.Result, 0, size)
+	timer := time.NewTimer(flushInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case result := <-results:
+			if len(batch) == 0 {
+				timer.Reset(flushInterval)
+			}
+			batch = append(batch, result)
+			if len(batch) < size && int64(len(batch)) < busy.Load() {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		timer.Stop()
+		if len(batch) == 0 {
+			continue
+		}
+		if err := a.reportBatch(batch); err != nil {
+			fmt.Printf("Agent %s failed to report %d results: %v\n", a.Name, len(batch), err)
+		}
+		busy.Add(-int64(len(batch)))
+		batch = batch[:0]
+	}
+}
+
+// reportBatch delivers results, retrying with backoff those that failed
+// transiently.
+func (a *Agent) reportBatch(results []models.Result) error {
+	for attempt := 1; ; attempt++ {
+		pending, err := a.setResults(results)
+		if len(pending) == 0 {
+			return err
+		}
+		if attempt >= resultRetry.Attempts() {
+			if err == nil {
+				err = fmt.Errorf("%d results were not accepted", len(pending))
+			}
+			return err
+		}
+		results = pending
+		time.Sleep(resultRetry.Delay(attempt))
+	}
+}
+
+// setResults posts a batch of results and returns the ones worth sending
+// again: all of them after a transport error or a 5xx answer, otherwise those
+// the scheduler was not available for.
+func (a *Agent) setResults(results []models.Result) ([]models.Result, error) {
+	resultsBody, err := json.Marshal(models.Results{Results: results})
+	if err != nil {
+		// Results that cannot be encoded are reported as AGENT_FAILURE, so
+		// that their tasks run again at once.
+		for i, result := range results {
+			if _, err := json.Marshal(result); err != nil {
+				fmt.Printf("Agent %s failed to encode the result of task %d: %v\n", a.Name, result.ID, err)
+				results[i] = failure(result.ID)
+			}
+		}
+		if resultsBody, err = json.Marshal(models.Results{Results: results}); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest("POST", "http://"+a.Host+":8080/internal/results", bytes.NewBuffer(resultsBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", a.Name)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return results, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("set results error: %d", resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return results, err
+		}
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses models.ResultStatuses
+	if err = json.Unmarshal(body, &statuses); err != nil {
+		return nil, err
+	}
+
+	var pending []models.Result
+	for i, status := range statuses.Results {
+		if status.Error != nil && status.Error.Code == errors.CodeNoScheduler && i < len(results) {
+			pending = append(pending, results[i])
+		}
+	}
+	return pending, nil
+}
+
+func (a *Agent) getTasks(limit int) ([]models.TaskData, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:8080/internal/tasks?max=%d", a.Host, limit), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.Name)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		return nil, a.register()
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var batch models.Tasks
+	if err = json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+	return batch.Tasks, nil
+}
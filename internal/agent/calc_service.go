@@ -5,25 +5,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net/http"
-	"sync"
+	"os"
 	"time"
 
 	"github.com/xKARASb/Calculator/pkg/models"
 	"github.com/xKARASb/Calculator/pkg/utils/errors"
-	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+	"github.com/xKARASb/Calculator/pkg/utils/retry"
 )
 
+// statusInterval is how often a running task is checked for cancellation.
+const statusInterval = 200 * time.Millisecond
+
+// resultRetry covers transport errors and 5xx answers while reporting a
+// result; a task that still could not be reported is reassigned by the
+// orchestrator once its lease expires.
+var resultRetry = retry.Policy{MaxAttempts: 5, Backoff: 200 * time.Millisecond, Jitter: 0.2}
+
 type Agent struct {
-	ID    int
-	mu    sync.Mutex
-	Host  string
-	token string
+	ID           int
+	Name         string
+	Host         string
+	Capabilities models.Capabilities
+	token        string
 }
 
 func NewAgent(id int, host string) *Agent {
-	agent := &Agent{ID: id, Host: host}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "agent"
+	}
+
+	agent := &Agent{ID: id, Name: fmt.Sprintf("%s-%d", hostname, id), Host: host}
 	token, err := agent.login("agent", "agent_password")
 	if err != nil {
 		fmt.Printf("Agent authentication error: %v\n", err)
@@ -76,65 +92,29 @@ func (a *Agent) CalculateExpression() error {
 
 	time.Sleep(time.Duration(localRand.Intn(1000)) * time.Millisecond)
 
-	var (
-		task *models.Task
-		err  error
-		id   int
-	)
+	if err := a.register(); err != nil {
+		fmt.Printf("Agent %s registration error: %v\n", a.Name, err)
+	}
 
 	for {
-		a.mu.Lock()
-		task, err = a.getTask()
+		task, err := a.getTask()
 		if err != nil || task == nil {
-			a.mu.Unlock()
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
-		id = task.Task.ID
+		result := compute(task.Task)
 
-		expression, err := a.getExpression(id)
-		if err != nil {
-			a.mu.Unlock()
-			time.Sleep(500 * time.Millisecond)
+		if !a.work(task.Task) {
+			fmt.Printf("Agent %s abandoned cancelled task %d\n", a.Name, task.Task.ID)
 			continue
 		}
 
-		if id == 0 || task.Task.OperationTime == 0 || expression.Expression.Status == statuses.StatusComplete {
-			a.mu.Unlock()
-			time.Sleep(500 * time.Millisecond)
+		if err = a.report(result); err != nil {
+			fmt.Printf("Agent %s failed to report task %d: %v\n", a.Name, task.Task.ID, err)
 			continue
 		}
 
-		a.mu.Unlock()
-
-		var result float64
-		switch task.Task.Operation {
-		case "+":
-			result = task.Task.Arg1 + task.Task.Arg2
-		case "-":
-			result = task.Task.Arg1 - task.Task.Arg2
-		case "*":
-			result = task.Task.Arg1 * task.Task.Arg2
-		case "/":
-			if task.Task.Arg2 == 0 {
-				return errors.ErrDivisionByZero
-			}
-			result = task.Task.Arg1 / task.Task.Arg2
-		}
-		{
-			a.mu.Lock()
-			err := a.setExpression(id, result)
-			if err != nil {
-				a.mu.Unlock()
-				time.Sleep(1 * time.Second)
-				continue
-			}
-			a.mu.Unlock()
-		}
-
-		time.Sleep(time.Duration(localRand.Intn(1000)+500) * time.Millisecond)
-
 		if a.token == "" {
 			token, err := a.login("agent", "agent_password")
 			if err == nil {
@@ -144,53 +124,175 @@ func (a *Agent) CalculateExpression() error {
 	}
 }
 
-func (a *Agent) setExpression(id int, result float64) error {
-	expr := models.Expression{Expression: models.ExpressionData{ID: id, Status: statuses.StatusComplete, Result: result}}
-	exprBody, err := json.Marshal(expr)
+// compute runs the operation in the precision the task asks for. A panic is
+// reported as AGENT_FAILURE, so the orchestrator runs the task again at once
+// instead of waiting for its lease to expire.
+func compute(task models.TaskData) (result models.Result) {
+	result = models.Result{ID: task.ID}
+	defer func() {
+		if recover() != nil {
+			result = failure(task.ID)
+		}
+	}()
+
+	arg1, arg2 := task.Arg1, task.Arg2
+	single := task.Precision == precisions.PrecisionSingle
+	if single {
+		arg1, arg2 = toSingle(arg1), toSingle(arg2)
+	}
+
+	switch task.Operation {
+	case "+":
+		result.Result = arg1 + arg2
+	case "-":
+		result.Result = arg1 - arg2
+	case "*":
+		result.Result = arg1 * arg2
+	case "/":
+		if arg2 == 0 {
+			result.Error = errors.CodeDivisionByZero
+			break
+		}
+		result.Result = arg1 / arg2
+	default:
+		result.Error = errors.CodeUnknownOperation
+	}
+	if single {
+		result.Result = toSingle(result.Result)
+	}
+
+	if math.IsInf(result.Result, 0) {
+		result.Error = errors.CodeOverflow
+	} else if math.IsNaN(result.Result) {
+		result.Error = errors.CodeNaN
+	}
+	return result
+}
+
+func failure(taskID int) models.Result {
+	return models.Result{ID: taskID, Error: errors.CodeAgentFailure}
+}
+
+// toSingle rounds value to float32, which overflows to infinity.
+func toSingle(value float64) float64 {
+	if math.Abs(value) > math.MaxFloat32 && !math.IsInf(value, 0) {
+		return math.Inf(int(math.Copysign(1, value)))
+	}
+	return float64(float32(value))
+}
+
+// register tells the orchestrator which operations and precisions the agent
+// executes; it only hands out such tasks afterwards.
+func (a *Agent) register() error {
+	body, err := json.Marshal(a.Capabilities)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", "http://"+a.Host+":8080/data/setExpression", bytes.NewBuffer(exprBody))
+	req, err := http.NewRequest("POST", "http://"+a.Host+":8080/internal/agents", bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+a.token)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", a.Name)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("register error: %d", resp.StatusCode)
+	}
 	return nil
 }
 
-func (a *Agent) getExpression(id int) (*models.Expression, error) {
-	req, err := http.NewRequest("GET", "http://"+a.Host+":8080/api/v1/expressions/"+fmt.Sprint(id), nil)
+// work spends the operation time on the task and reports false as soon as
+// the orchestrator says nobody is waiting for its result anymore. Tasks shorter
+// than statusInterval are never checked.
+func (a *Agent) work(task models.TaskData) bool {
+	deadline := time.Now().Add(time.Duration(task.OperationTime) * time.Millisecond)
+
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return true
+		}
+		time.Sleep(min(left, statusInterval))
+
+		if time.Until(deadline) > 0 && a.cancelled(task.ID) {
+			return false
+		}
+	}
+}
+
+func (a *Agent) cancelled(taskID int) bool {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:8080/internal/task/%d", a.Host, taskID), nil)
 	if err != nil {
-		return nil, err
+		return false
 	}
 
 	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.Name)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return false
 	}
+	resp.Body.Close()
 
-	exprBody, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode == http.StatusGone
+}
+
+// report delivers a result, retrying transient failures with backoff. A
+// result that cannot be encoded is reported as AGENT_FAILURE.
+func (a *Agent) report(result models.Result) error {
+	body, err := json.Marshal(result)
 	if err != nil {
-		return nil, err
+		fmt.Printf("Agent %s failed to encode the result of task %d: %v\n", a.Name, result.ID, err)
+		if body, err = json.Marshal(failure(result.ID)); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; attempt <= resultRetry.Attempts(); attempt++ {
+		var transient bool
+		if transient, err = a.setResult(body); err == nil || !transient {
+			return err
+		}
+		if attempt < resultRetry.Attempts() {
+			time.Sleep(resultRetry.Delay(attempt))
+		}
+	}
+	return err
+}
+
+// setResult posts an encoded result and tells whether a failure is worth
+// retrying.
+func (a *Agent) setResult(resultBody []byte) (bool, error) {
+	req, err := http.NewRequest("POST", "http://"+a.Host+":8080/internal/task", bytes.NewBuffer(resultBody))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent-ID", a.Name)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
 	}
 	resp.Body.Close()
 
-	var expression models.Expression
-	if err = json.Unmarshal(exprBody, &expression); err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		transient := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return transient, fmt.Errorf("set result error: %d", resp.StatusCode)
 	}
-	return &expression, nil
+	return false, nil
 }
 
 func (a *Agent) getTask() (task *models.Task, err error) {
@@ -200,14 +302,20 @@ func (a *Agent) getTask() (task *models.Task, err error) {
 	}
 
 	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("X-Agent-ID", a.Name)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusConflict {
+		// The registration expired while the agent was away.
+		resp.Body.Close()
+		return nil, a.register()
+	}
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, err
+		return nil, nil
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/xKARASb/Calculator/internal/orchestrator/delivery/rest/servers"
+	"github.com/xKARASb/Calculator/internal/orchestrator/repository"
 	"github.com/xKARASb/Calculator/pkg/db/cache"
 	"github.com/xKARASb/Calculator/pkg/db/postgres"
 
@@ -12,10 +13,16 @@ type Config struct {
 	postgres.PostgresConfig
 	cache.RedisConfig
 
-	CalculatorServerConfig servers.CalculatorServerConfig
-	ComputingPower         int    `env:"COMPUTING_POWER" env-default:"10"`
-	Port                   string `env:"PORT" env-default:"8080"`
-	OrchestratorHost       string `env:"ORCHESTRATOR_HOST" env-default:"localhost"`
+	CalculatorServerConfig     servers.CalculatorServerConfig
+	CalculatorRepositoryConfig repository.CalculatorRepositoryConfig
+	ComputingPower             int      `env:"COMPUTING_POWER" env-default:"10"`
+	AgentOperations            []string `env:"AGENT_OPERATIONS" env-default:"+,-,*,/"`
+	AgentPrecisions            []string `env:"AGENT_PRECISIONS" env-default:"single,double"`
+	AgentBatch                 bool     `env:"AGENT_BATCH" env-default:"true"`
+	Port                       string   `env:"PORT" env-default:"8080"`
+	OrchestratorHost           string   `env:"ORCHESTRATOR_HOST" env-default:"localhost"`
+	AdminLogin                 string   `env:"ADMIN_LOGIN" env-default:"admin"`
+	AdminPassword              string   `env:"ADMIN_PASSWORD"`
 }
 
 func NewConfig() (*Config, error) {
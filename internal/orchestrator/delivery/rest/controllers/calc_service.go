@@ -1,24 +1,57 @@
 package controllers
 
 import (
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/format"
 	"github.com/xKARASb/Calculator/pkg/utils/jwt"
+	"github.com/xKARASb/Calculator/pkg/utils/locale"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+	"github.com/xKARASb/Calculator/pkg/utils/uid"
 
 	"github.com/labstack/echo/v4"
 )
 
 type CalculatorService interface {
 	Calculate(expression string) (int, error)
+	Submit(user models.User, request models.Request) (*models.Response, error)
+	Schedule(user models.User, request models.Request) (*models.Schedule, error)
+	ListSchedules(user models.User) (*models.Schedules, error)
+	GetSchedule(user models.User, id int) (*models.Schedule, error)
+	DeleteSchedule(user models.User, id int) (*models.Schedule, error)
+	Validate(user models.User, request models.Request) (*models.Validation, error)
+	Convert(user models.User, request models.Request) (*models.Conversion, error)
 	GetAllExpressions() ([]models.Expression, error)
 	GetExpressionByID(id int) (*models.Expression, error)
-	GetCurrentTask() (*models.Task, error)
-	GetResult() (*models.Result, error)
+	ResolveExpressionID(uid string) (int, error)
+	GetSteps(id int) (*models.Steps, error)
+	GetTrace(id int) (*models.Trace, error)
+	Cancel(user models.User, id int) (*models.Expression, error)
+	TaskStatus(taskID int) error
+	NextTask(agentID string) (*models.Task, error)
+	SetTaskResult(agentID string, result models.Result) error
+	NextTasks(agentID string, limit int) (*models.Tasks, error)
+	SetTaskResults(agentID string, results []models.Result) (*models.ResultStatuses, error)
+	Metrics() (*models.Metrics, error)
 	SetExpression(expression models.Expression) error
 	Register(login string, password string) error
-	Login(login string, password string) error
+	Login(login string, password string) (*models.User, error)
+	GetUserLimits(userID int) (*models.UserLimits, error)
+	SetUserLimits(limits models.UserLimits) error
+	DeleteUserLimits(userID int) error
+	ListDeadLetters() (*models.DeadLetters, error)
+	GetDeadLetter(id int) (*models.DeadLetter, error)
+	RequeueDeadLetter(id int) (*models.DeadLetter, error)
+	DiscardDeadLetter(id int) (*models.DeadLetter, error)
+	ListAgents() (*models.Agents, error)
+	ReleaseAgent(agentID string) (*models.AgentInfo, error)
+	RegisterAgent(agentID string, capabilities models.Capabilities) error
 }
 
 type CalculatorController struct {
@@ -33,15 +66,19 @@ func (cc *CalculatorController) Calculate(c echo.Context) error {
 	var request models.Request
 
 	if err := c.Bind(&request); err != nil {
-		err = c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
-		return err
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	if request.RunAt != nil || request.Cron != "" {
+		schedule, err := cc.CalculatorService.Schedule(currentUser(c), request)
+		if err != nil {
+			return respondError(c, err)
+		}
+		return c.JSON(http.StatusCreated, schedule)
 	}
-	id, err := cc.CalculatorService.Calculate(request.Expression)
+	response, err := cc.CalculatorService.Submit(currentUser(c), request)
 	if err != nil {
-		err = c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
-		return err
+		return respondError(c, err)
 	}
-	response := models.Response{ID: id}
 	err = c.JSON(http.StatusOK, response)
 	if err != nil {
 		return err
@@ -49,52 +86,282 @@ func (cc *CalculatorController) Calculate(c echo.Context) error {
 	return nil
 }
 
+func (cc *CalculatorController) ListSchedules(c echo.Context) error {
+	schedules, err := cc.CalculatorService.ListSchedules(currentUser(c))
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, schedules)
+}
+
+func (cc *CalculatorController) GetSchedule(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	schedule, err := cc.CalculatorService.GetSchedule(currentUser(c), id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+func (cc *CalculatorController) DeleteSchedule(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	schedule, err := cc.CalculatorService.DeleteSchedule(currentUser(c), id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+func (cc *CalculatorController) Validate(c echo.Context) error {
+	var request models.Request
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	validation, err := cc.CalculatorService.Validate(currentUser(c), request)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, validation)
+}
+
+func (cc *CalculatorController) Convert(c echo.Context) error {
+	var request models.Request
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	conversion, err := cc.CalculatorService.Convert(currentUser(c), request)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, conversion)
+}
+
 func (cc *CalculatorController) GetAllExpressions(c echo.Context) error {
 	expressions, err := cc.CalculatorService.GetAllExpressions()
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return respondError(c, err)
 	}
 	return c.JSON(http.StatusOK, expressions)
 }
 
 func (cc *CalculatorController) GetExpressionByID(c echo.Context) error {
-	id, err := strconv.Atoi(c.Param("id"))
+	id, err := cc.expressionID(c)
 	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
+		return respondError(c, err)
+	}
+	overrides, err := formatQuery(c)
+	if err != nil {
+		return respondError(c, err)
 	}
 	expression, err := cc.CalculatorService.GetExpressionByID(id)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return respondError(c, err)
+	}
+
+	if expression.Expression.Status == statuses.StatusComplete {
+		var options format.Options
+		if expression.Expression.Format != nil {
+			options = *expression.Expression.Format
+		}
+		expression.Expression.Formatted = format.Format(expression.Expression.Result, options.Merge(overrides))
+	}
+
+	if mode := c.QueryParam("render"); mode != "" {
+		if expression.Expression.Rendered, err = render(expression.Expression, mode); err != nil {
+			return respondError(c, err)
+		}
+	}
+	return c.JSON(http.StatusOK, expression)
+}
+
+func render(expression models.ExpressionData, mode string) (string, error) {
+	if expression.Expression == "" {
+		return "", fmt.Errorf("%w: expression text was not stored", errors.ErrNotAvailable)
+	}
+
+	node, err := parser.ParseNotation(expression.Expression, expression.Notation)
+	if err != nil {
+		return "", err
+	}
+
+	var result *float64
+	if expression.Status == statuses.StatusComplete {
+		result = &expression.Result
+	}
+	return parser.Render(node, mode, result)
+}
+
+func (cc *CalculatorController) GetSteps(c echo.Context) error {
+	id, err := cc.expressionID(c)
+	if err != nil {
+		return respondError(c, err)
+	}
+	steps, err := cc.CalculatorService.GetSteps(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+
+	lang := locale.Language(c.Request().Header.Get("Accept-Language"))
+	for i, step := range steps.Steps {
+		steps.Steps[i].Text = locale.StepText(lang, step.Index, step.Expression, parser.FormatNumber(step.Value), step.Remaining)
+	}
+	return c.JSON(http.StatusOK, steps)
+}
+
+func (cc *CalculatorController) GetTrace(c echo.Context) error {
+	id, err := cc.expressionID(c)
+	if err != nil {
+		return respondError(c, err)
+	}
+	trace, err := cc.CalculatorService.GetTrace(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, trace)
+}
+
+func (cc *CalculatorController) Cancel(c echo.Context) error {
+	id, err := cc.expressionID(c)
+	if err != nil {
+		return respondError(c, err)
+	}
+	expression, err := cc.CalculatorService.Cancel(currentUser(c), id)
+	if err != nil {
+		return respondError(c, err)
 	}
 	return c.JSON(http.StatusOK, expression)
 }
 
-func (cc *CalculatorController) GetCurrentTask(c echo.Context) error {
-	task, err := cc.CalculatorService.GetCurrentTask()
+// expressionID reads the :id parameter, which is either the numeric ID or the
+// opaque UID of an expression.
+func (cc *CalculatorController) expressionID(c echo.Context) (int, error) {
+	param := c.Param("id")
+	if uid.Valid(param) {
+		return cc.CalculatorService.ResolveExpressionID(param)
+	}
+
+	id, err := strconv.Atoi(param)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return 0, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err)
+	}
+	return id, nil
+}
+
+func formatQuery(c echo.Context) (format.Options, error) {
+	options := format.Options{
+		Format:   c.QueryParam("format"),
+		Rounding: c.QueryParam("rounding"),
+	}
+
+	if value := c.QueryParam("round"); value != "" {
+		round, err := strconv.Atoi(value)
+		if err != nil {
+			return options, fmt.Errorf("%w: %s", errors.ErrInvalidFormat, err)
+		}
+		options.Round = &round
+	}
+
+	return options, format.Validate(options)
+}
+
+func (cc *CalculatorController) NextTask(c echo.Context) error {
+	task, err := cc.CalculatorService.NextTask(c.Request().Header.Get("X-Agent-ID"))
+	if err != nil {
+		return respondError(c, err)
 	}
 	return c.JSON(http.StatusOK, task)
 }
 
-func (cc *CalculatorController) GetResult(c echo.Context) error {
-	result, err := cc.CalculatorService.GetResult()
+func (cc *CalculatorController) RegisterAgent(c echo.Context) error {
+	var request models.Capabilities
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	err := cc.CalculatorService.RegisterAgent(c.Request().Header.Get("X-Agent-ID"), request)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "registered"})
+}
+
+func (cc *CalculatorController) TaskStatus(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	if err = cc.CalculatorService.TaskStatus(id); err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "running"})
+}
+
+func (cc *CalculatorController) SetTaskResult(c echo.Context) error {
+	var request models.Result
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	err := cc.CalculatorService.SetTaskResult(c.Request().Header.Get("X-Agent-ID"), request)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "success"})
+}
+
+func (cc *CalculatorController) NextTasks(c echo.Context) error {
+	limit := 1
+	if value := c.QueryParam("max"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return respondError(c, fmt.Errorf("%w: max must be a positive number", errors.ErrInvalidRequest))
+		}
+	}
+	tasks, err := cc.CalculatorService.NextTasks(c.Request().Header.Get("X-Agent-ID"), limit)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, tasks)
+}
+
+func (cc *CalculatorController) SetTaskResults(c echo.Context) error {
+	var request models.Results
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	reply, err := cc.CalculatorService.SetTaskResults(c.Request().Header.Get("X-Agent-ID"), request.Results)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, reply)
+}
+
+func (cc *CalculatorController) Metrics(c echo.Context) error {
+	metrics, err := cc.CalculatorService.Metrics()
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return respondError(c, err)
 	}
-	return c.JSON(http.StatusOK, result)
+	return c.JSON(http.StatusOK, metrics)
 }
 
 func (cc *CalculatorController) SetExpression(c echo.Context) error {
 	var request models.Expression
 
 	if err := c.Bind(&request); err != nil {
-		err = c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
-		return err
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
 	}
 	err := cc.CalculatorService.SetExpression(request)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return respondError(c, err)
 	}
 	return c.JSON(http.StatusOK, echo.Map{"status": "success"})
 }
@@ -103,12 +370,11 @@ func (cc *CalculatorController) Register(c echo.Context) error {
 	var request models.Auth
 
 	if err := c.Bind(&request); err != nil {
-		err = c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
-		return err
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
 	}
 	err := cc.CalculatorService.Register(request.Login, request.Password)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return respondError(c, err)
 	}
 	return c.JSON(http.StatusOK, echo.Map{"status": "success"})
 }
@@ -117,18 +383,135 @@ func (cc *CalculatorController) Login(c echo.Context) error {
 	var request models.Auth
 
 	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error()})
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
 	}
 
-	err := cc.CalculatorService.Login(request.Login, request.Password)
+	user, err := cc.CalculatorService.Login(request.Login, request.Password)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		return respondError(c, err)
 	}
 
-	token := jwt.NewAccessToken(1, "secret")
+	token := jwt.NewAccessToken(int64(user.ID), user.Role, "secret")
 
 	return c.JSON(http.StatusOK, echo.Map{
 		"status": "success",
 		"token":  token,
 	})
 }
+
+func (cc *CalculatorController) GetUserLimits(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	limits, err := cc.CalculatorService.GetUserLimits(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, limits)
+}
+
+func (cc *CalculatorController) SetUserLimits(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+
+	var request models.UserLimits
+
+	if err := c.Bind(&request); err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	request.UserID = id
+
+	if err = cc.CalculatorService.SetUserLimits(request); err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+func (cc *CalculatorController) DeleteUserLimits(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	if err = cc.CalculatorService.DeleteUserLimits(id); err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "success"})
+}
+
+func (cc *CalculatorController) ListDeadLetters(c echo.Context) error {
+	letters, err := cc.CalculatorService.ListDeadLetters()
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, letters)
+}
+
+func (cc *CalculatorController) GetDeadLetter(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	letter, err := cc.CalculatorService.GetDeadLetter(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, letter)
+}
+
+func (cc *CalculatorController) RequeueDeadLetter(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	letter, err := cc.CalculatorService.RequeueDeadLetter(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, letter)
+}
+
+func (cc *CalculatorController) DiscardDeadLetter(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return respondError(c, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err))
+	}
+	letter, err := cc.CalculatorService.DiscardDeadLetter(id)
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, letter)
+}
+
+func (cc *CalculatorController) ListAgents(c echo.Context) error {
+	agents, err := cc.CalculatorService.ListAgents()
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, agents)
+}
+
+func (cc *CalculatorController) ReleaseAgent(c echo.Context) error {
+	agent, err := cc.CalculatorService.ReleaseAgent(c.Param("id"))
+	if err != nil {
+		return respondError(c, err)
+	}
+	return c.JSON(http.StatusOK, agent)
+}
+
+func currentUser(c echo.Context) models.User {
+	id, role := jwt.Claims(c)
+	return models.User{ID: id, Role: role}
+}
+
+func respondError(c echo.Context, err error) error {
+	if after := errors.RetryAfter(err); after > 0 {
+		seconds := int(math.Ceil(after.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	status, response := errors.NewResponse(err)
+	return c.JSON(status, response)
+}
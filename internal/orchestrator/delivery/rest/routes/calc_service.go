@@ -4,6 +4,7 @@ import (
 	"github.com/xKARASb/Calculator/internal/orchestrator/delivery/rest/controllers"
 	"github.com/xKARASb/Calculator/internal/orchestrator/service"
 	"github.com/xKARASb/Calculator/pkg/utils/jwt"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
 
 	"github.com/labstack/echo/v4"
 )
@@ -19,12 +20,37 @@ func CalculatorRoutes(e *echo.Echo, calculatorService service.CalculatorService)
 	api.Use(jwt.JWTAuth)
 
 	api.POST("/calculate", CalculatorController.Calculate)
+	api.POST("/validate", CalculatorController.Validate)
+	api.POST("/convert", CalculatorController.Convert)
 	api.GET("/expressions", CalculatorController.GetAllExpressions)
 	api.GET("/expressions/:id", CalculatorController.GetExpressionByID)
+	api.GET("/expressions/:id/steps", CalculatorController.GetSteps)
+	api.GET("/expressions/:id/trace", CalculatorController.GetTrace)
+	api.DELETE("/expressions/:id", CalculatorController.Cancel)
+	api.POST("/expressions/:id/cancel", CalculatorController.Cancel)
+	api.GET("/schedules", CalculatorController.ListSchedules)
+	api.GET("/schedules/:id", CalculatorController.GetSchedule)
+	api.DELETE("/schedules/:id", CalculatorController.DeleteSchedule)
+
+	admin := api.Group("/admin", jwt.RequireRole(roles.RoleAdmin))
+	admin.GET("/users/:id/limits", CalculatorController.GetUserLimits)
+	admin.PUT("/users/:id/limits", CalculatorController.SetUserLimits)
+	admin.DELETE("/users/:id/limits", CalculatorController.DeleteUserLimits)
+	admin.GET("/dead-letters", CalculatorController.ListDeadLetters)
+	admin.GET("/dead-letters/:id", CalculatorController.GetDeadLetter)
+	admin.POST("/dead-letters/:id/requeue", CalculatorController.RequeueDeadLetter)
+	admin.DELETE("/dead-letters/:id", CalculatorController.DiscardDeadLetter)
+	admin.GET("/agents", CalculatorController.ListAgents)
+	admin.DELETE("/agents/:id/quarantine", CalculatorController.ReleaseAgent)
 
 	internal := e.Group("/internal")
-	internal.GET("/task", CalculatorController.GetCurrentTask)
-	internal.POST("/task", CalculatorController.GetResult)
+	internal.POST("/agents", CalculatorController.RegisterAgent)
+	internal.GET("/task", CalculatorController.NextTask)
+	internal.POST("/task", CalculatorController.SetTaskResult)
+	internal.GET("/task/:id", CalculatorController.TaskStatus)
+	internal.GET("/tasks", CalculatorController.NextTasks)
+	internal.POST("/results", CalculatorController.SetTaskResults)
+	internal.GET("/metrics", CalculatorController.Metrics)
 
 	data := e.Group("/data")
 	data.POST("/setExpression", CalculatorController.SetExpression)
@@ -7,10 +7,12 @@ import (
 	"github.com/xKARASb/Calculator/internal/orchestrator/service"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 type CalculatorServerConfig struct {
-	Port string `env:"PORT" env-default:"8080"`
+	Port      string `env:"PORT" env-default:"8080"`
+	BodyLimit string `env:"BODY_LIMIT" env-default:"1M"`
 }
 
 type CalculatorServer struct {
@@ -20,6 +22,7 @@ type CalculatorServer struct {
 
 func NewCalculatorServer(cfg CalculatorServerConfig, service service.CalculatorService) *CalculatorServer {
 	e := echo.New()
+	e.Use(middleware.BodyLimit(cfg.BodyLimit))
 	routes.CalculatorRoutes(e, service)
 	return &CalculatorServer{cfg: cfg, engine: e}
 }
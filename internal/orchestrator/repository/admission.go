@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+)
+
+// heldExpression waits in the overflow buffer; ready is closed once its tasks
+// fit into the queue.
+type heldExpression struct {
+	tasks int
+	ready chan struct{}
+}
+
+// queueLimit is how many tasks may wait for agents: QUEUE_LIMIT, lowered to
+// QUEUE_PER_AGENT tasks for every task the live agents run at once. Zero
+// means no limit.
+func (r *CalculatorRepository) queueLimit(capacity int) int {
+	limit := r.cfg.QueueLimit
+	if r.cfg.QueuePerAgent > 0 {
+		byAgents := r.cfg.QueuePerAgent * max(capacity, 1)
+		if limit <= 0 || byAgents < limit {
+			limit = byAgents
+		}
+	}
+	return limit
+}
+
+// room reports whether tasks more tasks fit into the queue, and otherwise how
+// long the agents need to work off the excess. An empty queue takes any
+// expression, so that one larger than the limit still runs.
+//
+// Admitted expressions enqueue their tasks one tree level at a time, so every
+// task they have not finished yet counts as waiting, except the ones agents
+// are running.
+func (r *CalculatorRepository) room(tasks int) (time.Duration, bool) {
+	capacity, err := r.LiveAgents()
+	if err != nil {
+		return 0, true
+	}
+
+	limit := r.queueLimit(capacity)
+	if limit <= 0 {
+		return 0, true
+	}
+
+	stats := r.queue.Stats()
+	r.mu.Lock()
+	pending := max(stats.Pending, r.reserved-stats.Running)
+	r.mu.Unlock()
+
+	excess := pending + tasks - limit
+	if excess <= 0 || pending == 0 {
+		return 0, true
+	}
+	return drainTime(r.queue.Waiting(), excess, capacity), false
+}
+
+// drainTime estimates how long capacity agents need for excess tasks that
+// take as long as the waiting ones on average.
+func drainTime(waiting []models.PendingTask, excess, capacity int) time.Duration {
+	total := 0
+	for _, pending := range waiting {
+		total += pending.Task.OperationTime
+	}
+
+	var average time.Duration
+	if len(waiting) > 0 {
+		average = time.Duration(total/len(waiting)) * time.Millisecond
+	}
+	return max(time.Duration(excess)*average/time.Duration(max(capacity, 1)), time.Second)
+}
+
+// admit lets an expression start right away, holds it in the overflow buffer
+// while earlier expressions wait there or the queue is full, or rejects it
+// with the time after which the client should retry. An expression let in
+// reserves room for all its tasks.
+func (r *CalculatorRepository) admit(tasks int) (*heldExpression, error) {
+	r.admission.Lock()
+	defer r.admission.Unlock()
+
+	r.mu.Lock()
+	waiting := len(r.held)
+	r.mu.Unlock()
+
+	after, ok := r.room(tasks)
+	if ok && waiting == 0 {
+		r.reserve(tasks)
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.held) >= r.cfg.OverflowSize {
+		return nil, &errors.RetryError{Err: errors.ErrQueueFull, After: after}
+	}
+
+	held := &heldExpression{tasks: tasks, ready: make(chan struct{})}
+	r.held = append(r.held, held)
+	return held, nil
+}
+
+// awaitAdmission blocks a held expression until the overflow buffer lets it
+// in or the expression is stopped.
+func (r *CalculatorRepository) awaitAdmission(ctx context.Context, e *evaluation) error {
+	if e.held == nil {
+		return nil
+	}
+
+	select {
+	case <-e.held.ready:
+		return nil
+	case <-ctx.Done():
+		r.cancelAdmission(e.held, e.tasks)
+		return ctx.Err()
+	}
+}
+
+// cancelAdmission takes an expression that will not run out of the overflow
+// buffer, or gives back the room it reserved once it was let in.
+func (r *CalculatorRepository) cancelAdmission(held *heldExpression, tasks int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := slices.Index(r.held, held); held != nil && i >= 0 {
+		r.held = slices.Delete(r.held, i, i+1)
+		return
+	}
+	r.reserved -= tasks
+}
+
+func (r *CalculatorRepository) reserve(tasks int) {
+	r.mu.Lock()
+	r.reserved += tasks
+	r.mu.Unlock()
+}
+
+// taskFinished gives back the room of one finished task of the expression.
+func (r *CalculatorRepository) taskFinished(e *evaluation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e.reserved > 0 {
+		e.reserved--
+		r.reserved--
+	}
+}
+
+// releaseReserved gives back the room of the tasks the expression will not
+// run anymore.
+func (r *CalculatorRepository) releaseReserved(e *evaluation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reserved -= e.reserved
+	e.reserved = 0
+}
+
+// drain starts held expressions in order as long as their tasks fit.
+func (r *CalculatorRepository) drain(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.release()
+		}
+	}
+}
+
+func (r *CalculatorRepository) release() {
+	r.admission.Lock()
+	defer r.admission.Unlock()
+
+	for {
+		r.mu.Lock()
+		if len(r.held) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		first := r.held[0]
+		r.mu.Unlock()
+
+		if _, ok := r.room(first.tasks); !ok {
+			return
+		}
+
+		r.mu.Lock()
+		if len(r.held) > 0 && r.held[0] == first {
+			r.held = r.held[1:]
+			r.reserved += first.tasks
+			close(first.ready)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// stopHeld saves the final status of an expression stopped while it was
+// held.
+func (r *CalculatorRepository) stopHeld(e *evaluation) {
+	e.mu.Lock()
+	reason := e.reason
+	e.mu.Unlock()
+
+	// The orchestrator is shutting down; the expression stays pending.
+	if reason == "" {
+		return
+	}
+
+	expr := models.Expression{Expression: expressionData(e.id, reason, e.user, e.request)}
+	expr.Expression.UID = e.uid
+	expr.Expression.TasksTotal = e.tasks
+	expr.Expression.ElapsedMS = time.Since(e.submitted).Milliseconds()
+	if reason == statuses.StatusTimeout {
+		expr.Expression.Error = errors.NewBody(errors.ErrDeadlineExceeded)
+	}
+	if err := r.SetExpression(expr); err != nil {
+		log.Println("Failed to save id:", e.id, err)
+	}
+}
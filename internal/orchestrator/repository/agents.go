@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	knownOperations = []string{"+", "-", "*", "/"}
+	knownPrecisions = []string{precisions.PrecisionSingle, precisions.PrecisionDouble}
+)
+
+func agentKey(agentID string) string {
+	return "agent:" + agentID + ":capabilities"
+}
+
+// RegisterAgent stores what the agent is able to execute. The registration
+// lives as long as the agent keeps fetching tasks or answering them; see
+// touchAgent.
+func (r *CalculatorRepository) RegisterAgent(agentID string, capabilities models.Capabilities) error {
+	if agentID == "" {
+		return fmt.Errorf("%w: X-Agent-ID header is required", errors.ErrInvalidRequest)
+	}
+	for _, operation := range capabilities.Operations {
+		if !slices.Contains(knownOperations, operation) {
+			return fmt.Errorf("%w: unknown operation %q", errors.ErrInvalidRequest, operation)
+		}
+	}
+	for _, precision := range capabilities.Precisions {
+		if !precisions.Valid(precision) {
+			return fmt.Errorf("%w: unknown precision %q", errors.ErrInvalidRequest, precision)
+		}
+	}
+	if capabilities.Concurrency < 0 {
+		return fmt.Errorf("%w: concurrency must not be negative", errors.ErrInvalidRequest)
+	}
+
+	data, err := json.Marshal(capabilities)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(r.ctx, agentKey(agentID), string(data), agentTTL)
+}
+
+// agentCapabilities returns what a registered agent executes. Requests
+// without an agent ID are not restricted.
+func (r *CalculatorRepository) agentCapabilities(agentID string) (models.Capabilities, error) {
+	var capabilities models.Capabilities
+	if agentID == "" {
+		return capabilities, nil
+	}
+
+	data, err := r.redis.Get(r.ctx, agentKey(agentID))
+	if err == redis.Nil {
+		return capabilities, errors.ErrAgentNotRegistered
+	}
+	if err != nil {
+		return capabilities, err
+	}
+
+	err = json.Unmarshal([]byte(data), &capabilities)
+	return capabilities, err
+}
+
+// dispatchable returns what the agent may be given; quarantined agents get
+// nothing.
+func (r *CalculatorRepository) dispatchable(agentID string) (models.Capabilities, error) {
+	if agentID != "" {
+		scores, err := r.disagreements()
+		if err != nil {
+			return models.Capabilities{}, err
+		}
+		if r.quarantined(scores[agentID]) {
+			return models.Capabilities{}, errors.ErrAgentQuarantined
+		}
+	}
+	return r.agentCapabilities(agentID)
+}
+
+// liveCapabilities returns the capabilities of every registered live agent
+// that is not quarantined.
+func (r *CalculatorRepository) liveCapabilities() (map[string]models.Capabilities, error) {
+	since := time.Now().Add(-agentTTL).UnixMilli()
+
+	ids, err := r.redis.ZRangeByScore(r.ctx, "agents:seen", strconv.FormatInt(since, 10), "+inf")
+	if err != nil {
+		return nil, err
+	}
+
+	scores, err := r.disagreements()
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make(map[string]models.Capabilities, len(ids))
+	for _, id := range ids {
+		if r.quarantined(scores[id]) {
+			continue
+		}
+		capabilities, err := r.agentCapabilities(id)
+		if err == errors.ErrAgentNotRegistered {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		agents[id] = capabilities
+	}
+	return agents, nil
+}
+
+// unschedulable picks the waiting tasks that too few of the agents execute.
+func unschedulable(waiting []models.PendingTask, agents map[string]models.Capabilities) []models.PendingTask {
+	tasks := make([]models.PendingTask, 0)
+	for _, pending := range waiting {
+		if !servable(pending.Task, agents) {
+			tasks = append(tasks, pending)
+		}
+	}
+	return tasks
+}
+
+// servable reports whether enough agents execute the task: one, or as many
+// as a verified task needs.
+func servable(task models.TaskData, agents map[string]models.Capabilities) bool {
+	count := 0
+	for _, capabilities := range agents {
+		if capabilities.Supports(task) {
+			count++
+		}
+	}
+	return count >= max(task.Verify, 1)
+}
+
+// isUnschedulable tells whether the expression waits for a task that no live
+// agent executes.
+func (r *CalculatorRepository) isUnschedulable(id int) bool {
+	var waiting []models.PendingTask
+	for _, pending := range r.queue.Waiting() {
+		if slices.Contains(pending.Expressions, id) {
+			waiting = append(waiting, pending)
+		}
+	}
+	if len(waiting) == 0 {
+		return false
+	}
+
+	agents, err := r.liveCapabilities()
+	if err != nil {
+		return false
+	}
+	return len(unschedulable(waiting, agents)) > 0
+}
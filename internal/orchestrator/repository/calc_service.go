@@ -2,55 +2,200 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"sort"
 	"strconv"
 	"sync"
 	"time"
-	"unicode"
 
 	"github.com/xKARASb/Calculator/pkg/db/cache"
 	"github.com/xKARASb/Calculator/pkg/db/postgres"
 	"github.com/xKARASb/Calculator/pkg/models"
 	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/format"
 	"github.com/xKARASb/Calculator/pkg/utils/hash"
 	"github.com/xKARASb/Calculator/pkg/utils/jwt"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+	"github.com/xKARASb/Calculator/pkg/utils/priorities"
+	"github.com/xKARASb/Calculator/pkg/utils/retry"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
 	"github.com/xKARASb/Calculator/pkg/utils/statuses"
-	"github.com/xKARASb/Calculator/pkg/utils/timings"
+	"github.com/xKARASb/Calculator/pkg/utils/uid"
 
 	"github.com/lib/pq"
 )
 
+const agentTTL = 10 * time.Second
+
+type CalculatorRepositoryConfig struct {
+	ResultCacheTTL      time.Duration     `env:"RESULT_CACHE_TTL" env-default:"1h"`
+	TaskMemoTTL         time.Duration     `env:"TASK_MEMO_TTL" env-default:"0"`
+	MaxExpressionLength int               `env:"MAX_EXPRESSION_LENGTH" env-default:"1000"`
+	MaxDepth            int               `env:"MAX_AST_DEPTH" env-default:"100"`
+	MaxTasks            int               `env:"MAX_TASKS" env-default:"500"`
+	MaxEstimatedMS      int               `env:"MAX_ESTIMATED_MS" env-default:"60000"`
+	DefaultTimeout      time.Duration     `env:"DEFAULT_TIMEOUT" env-default:"0"`
+	OpaqueIDs           bool              `env:"OPAQUE_IDS" env-default:"false"`
+	RetryMaxAttempts    int               `env:"RETRY_MAX_ATTEMPTS" env-default:"3"`
+	RetryBackoff        time.Duration     `env:"RETRY_BACKOFF" env-default:"200ms"`
+	RetryJitter         float64           `env:"RETRY_JITTER" env-default:"0.2"`
+	RetryPolicies       map[string]string `env:"RETRY_POLICIES"`
+	TaskLeaseTimeout    time.Duration     `env:"TASK_LEASE_TIMEOUT" env-default:"10s"`
+	SpeculationFactor   float64           `env:"SPECULATION_FACTOR" env-default:"2"`
+	SpeculationDelay    time.Duration     `env:"SPECULATION_DELAY" env-default:"1s"`
+	VerifyMaxAgents     int               `env:"VERIFY_MAX_AGENTS" env-default:"5"`
+	VerifyTolerance     float64           `env:"VERIFY_TOLERANCE" env-default:"1e-9"`
+	QuarantineThreshold int               `env:"QUARANTINE_THRESHOLD" env-default:"3"`
+	QueueLimit          int               `env:"ADMISSION_QUEUE_LIMIT" env-default:"10000"`
+	QueuePerAgent       int               `env:"ADMISSION_QUEUE_PER_AGENT" env-default:"100"`
+	OverflowSize        int               `env:"ADMISSION_OVERFLOW" env-default:"0"`
+	ScheduleHistory     int               `env:"SCHEDULE_HISTORY" env-default:"100"`
+	DeadLetterTTL       time.Duration     `env:"DEAD_LETTER_TTL" env-default:"168h"`
+	ClusterMode         bool              `env:"CLUSTER_MODE" env-default:"false"`
+	ReplicaID           string            `env:"REPLICA_ID"`
+	ReadyDepth          int               `env:"CLUSTER_READY_DEPTH" env-default:"4"`
+}
+
+// taskScheduler is the task queue as seen by expressions and agents: the
+// local TaskQueue, or the one run by the cluster leader.
+type taskScheduler interface {
+	EnqueueFor(owner TaskOwner, arg1, arg2 float64, operation string) <-chan TaskOutcome
+	Withdraw(done <-chan TaskOutcome)
+	NextFor(agentID string, capabilities models.Capabilities) (*models.Task, error)
+	Peek() (*models.Task, error)
+	CompleteBy(agentID string, result models.Result) error
+	Status(id int) error
+	Position(expression int) int
+	Stats() models.QueueStats
+	Waiting() []models.PendingTask
+	Last() (*models.Result, error)
+	Requeue(id int) error
+	Discard(id int) error
+}
+
 type CalculatorRepository struct {
-	ctx   context.Context
-	id    int
-	task  chan models.Task
-	db    *postgres.DB
-	redis *cache.RedisClient
-	mu    sync.Mutex
+	ctx      context.Context
+	cfg      CalculatorRepositoryConfig
+	queue    taskScheduler
+	cluster  *cluster
+	policies retry.Policies
+	evals    map[int]*evaluation
+	held     []*heldExpression
+	reserved int
+	db       *postgres.DB
+	redis    *cache.RedisClient
+	mu       sync.Mutex
+
+	admission sync.Mutex
 }
 
-func NewCalculatorRepository(ctx context.Context, db *postgres.DB, redis *cache.RedisClient) *CalculatorRepository {
+func NewCalculatorRepository(ctx context.Context, cfg CalculatorRepositoryConfig, db *postgres.DB, redis *cache.RedisClient) *CalculatorRepository {
 	repo := &CalculatorRepository{
 		ctx:   ctx,
-		id:    0,
-		task:  make(chan models.Task, 1),
+		cfg:   cfg,
+		evals: make(map[int]*evaluation),
 		db:    db,
 		redis: redis,
 	}
 
-	lastID, err := repo.getLastID()
-	if err == nil && lastID > 0 {
-		repo.id = lastID
-		log.Printf("Last ID was restored from Redis: %d", lastID)
+	policy := retry.Policy{MaxAttempts: cfg.RetryMaxAttempts, Backoff: cfg.RetryBackoff, Jitter: cfg.RetryJitter}
+	policies, err := retry.ParsePolicies(policy, cfg.RetryPolicies)
+	if err != nil {
+		log.Printf("Invalid RETRY_POLICIES, using the default policy: %v", err)
+		policies = retry.Policies{Default: policy}
+	}
+	repo.policies = policies
+
+	if cfg.ClusterMode {
+		repo.cluster = newCluster(repo)
+		repo.queue = repo.cluster
+		go repo.cluster.run()
+	} else {
+		queue := repo.newQueue()
+		repo.queue = queue
+		if cfg.TaskLeaseTimeout > 0 {
+			go repo.reap(ctx, queue)
+		}
+	}
+
+	if cfg.OverflowSize > 0 {
+		go repo.drain(ctx)
+	}
+	go repo.runSchedules(ctx)
+
+	if err = repo.seedID(); err != nil {
+		log.Println("Failed to seed expression IDs:", err)
 	}
 
 	return repo
 }
 
+// taskIDsPerRun spaces task IDs of consecutive queues, so that a result or a
+// dead letter left by an earlier run never matches a task of the current one.
+const taskIDsPerRun = 1000000000
+
+func (r *CalculatorRepository) newQueue() *TaskQueue {
+	queue := NewTaskQueue(r.cfg.TaskMemoTTL)
+	queue.SetRetryPolicy(r.policies, r.cfg.TaskLeaseTimeout)
+	queue.SetSpeculation(r.cfg.SpeculationFactor, r.cfg.SpeculationDelay)
+	queue.SetVerification(r.cfg.VerifyTolerance, r.recordDisagreement)
+	queue.SetDeadLetter(r.storeDeadLetter)
+
+	run, err := r.redis.Incr(r.ctx, "tasks:runs")
+	if err != nil {
+		log.Println("Failed to allocate task IDs:", err)
+	}
+	queue.SetIDBase(int(run) * taskIDsPerRun)
+	return queue
+}
+
+// reap periodically hands tasks of silent agents back to the queue.
+func (r *CalculatorRepository) reap(ctx context.Context, queue *TaskQueue) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			queue.Reap(now)
+		}
+	}
+}
+
+const expressionIDKey = "expressions:id"
+
+func expressionUIDKey(uid string) string {
+	return "expression:uid:" + uid
+}
+
+// seedID starts the shared ID counter after the largest ID stored by
+// orchestrators that still kept it in memory. Only the first replica to get
+// here seeds it.
+func (r *CalculatorRepository) seedID() error {
+	if _, err := r.redis.Get(r.ctx, expressionIDKey); err == nil {
+		return nil
+	}
+
+	lastID, err := r.getLastID()
+	if err != nil && err != errors.ErrNotFound {
+		return err
+	}
+
+	seeded, err := r.redis.SetNX(r.ctx, expressionIDKey, strconv.Itoa(lastID), 0)
+	if err == nil && seeded && lastID > 0 {
+		log.Printf("Last ID was restored from Redis: %d", lastID)
+	}
+	return err
+}
+
 func (r *CalculatorRepository) getLastID() (int, error) {
 	ids, err := r.redis.SMembers(r.ctx, "expressions:all")
 	if err != nil || len(ids) == 0 {
@@ -81,194 +226,322 @@ func (r *CalculatorRepository) getLastID() (int, error) {
 }
 
 func (r *CalculatorRepository) Calculate(expression string) (int, error) {
-	r.mu.Lock()
+	response, err := r.Submit(models.User{}, models.Request{Expression: expression})
+	if err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+func (r *CalculatorRepository) Submit(user models.User, request models.Request) (*models.Response, error) {
+	request, node, err := r.check(user, request)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := parser.Analyze(node).Tasks
 
-	if len(r.task) > 0 {
-		<-r.task
+	// A verified expression is never answered with a result no one verified.
+	cacheKey := resultCacheKey(node, request.Precision)
+	if request.Verify <= 1 {
+		if response, ok := r.fromCache(cacheKey, user, request, tasks); ok {
+			return response, nil
+		}
 	}
 
-	r.id++
-	id := r.id
+	held, err := r.admit(tasks)
+	if err != nil {
+		return nil, err
+	}
 
-	expr := models.Expression{Expression: models.ExpressionData{ID: id, Status: statuses.StatusPending}}
-	err := r.SetExpression(expr)
+	id, uid, err := r.nextID()
 	if err != nil {
-		r.mu.Unlock()
-		return 0, err
+		r.cancelAdmission(held, tasks)
+		return nil, err
 	}
 
-	r.mu.Unlock()
+	e := r.newEvaluation(id, user, request, node, cacheKey, tasks, r.timeout(request))
+	e.uid = uid
+	e.held = held
 
-	expr = models.Expression{Expression: models.ExpressionData{ID: id, Status: statuses.StatusProgress}}
+	expr := models.Expression{Expression: expressionData(id, statuses.StatusPending, user, request)}
+	expr.Expression.UID = uid
+	expr.Expression.TasksTotal = e.tasks
+	expr.Expression.EtaMS = r.eta(node)
+	expr.Expression.Deadline = e.deadline()
+	if err = r.SetExpression(expr); err != nil {
+		r.cancelAdmission(held, tasks)
+		r.unregister(e)
+		return nil, err
+	}
+
+	go r.run(e)
 
-	err = r.SetExpression(expr)
+	return &models.Response{ID: id, UID: uid}, nil
+}
+
+// check validates the request, fills in its defaults and parses the
+// expression.
+func (r *CalculatorRepository) check(user models.User, request models.Request) (models.Request, *parser.Node, error) {
+	if err := format.Validate(request.Options); err != nil {
+		return request, nil, err
+	}
+	if request.TimeoutMS < 0 {
+		return request, nil, fmt.Errorf("%w: timeout_ms must not be negative", errors.ErrInvalidRequest)
+	}
+
+	priority, err := priorityFor(user, request.Priority)
 	if err != nil {
-		return 0, err
+		return request, nil, err
 	}
+	request.Priority = priority
 
-	task := models.Task{Task: models.TaskData{
-		ID: r.id,
-	}}
+	if request.Precision == "" {
+		request.Precision = precisions.PrecisionDouble
+	}
+	if !precisions.Valid(request.Precision) {
+		return request, nil, fmt.Errorf("%w: unknown precision %q", errors.ErrInvalidRequest, request.Precision)
+	}
+	if request.Verify < 0 || request.Verify > r.cfg.VerifyMaxAgents {
+		return request, nil, fmt.Errorf("%w: verify must be between 0 and %d", errors.ErrInvalidRequest, r.cfg.VerifyMaxAgents)
+	}
 
-	r.task <- task
+	node, err := r.parse(user, request)
+	return request, node, err
+}
 
-	err = r.prioritize(expression)
-	if err == nil {
-		log.Println("Passed id:", id)
-	} else {
-		log.Println("Failed id:", id)
-		return 0, err
+func (r *CalculatorRepository) parse(user models.User, request models.Request) (*parser.Node, error) {
+	limits, err := r.limitsFor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := parseInput(limits, request.Expression, request.Notation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = parser.Check(node); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkLimits(limits, node); err != nil {
+		return nil, err
 	}
 
-	return id, err
+	return node, nil
 }
 
-func (r *CalculatorRepository) prioritize(expression string) (err error) {
-	var values []float64
-	var ops []byte
+// nextID allocates an expression ID in Redis, so replicas sharing the store
+// never hand out the same one. With OPAQUE_IDS a random UID comes with it.
+func (r *CalculatorRepository) nextID() (int, string, error) {
+	id, err := r.redis.Incr(r.ctx, expressionIDKey)
+	if err != nil {
+		return 0, "", err
+	}
 
-	task := models.Task{}
-	task.Task.ID = r.id
+	if !r.cfg.OpaqueIDs {
+		return int(id), "", nil
+	}
+	return int(id), uid.New(), nil
+}
 
-	applyOperation := func(op byte, a, b float64) (float64, error) {
-		task.Task.Arg1 = a
-		task.Task.Arg2 = b
-		task.Task.Operation = string(op)
-		switch op {
-		case '+':
-			task.Task.OperationTime = timings.TimeAdditionMS
-		case '-':
-			task.Task.OperationTime = timings.TimeSubtractionMS
-		case '*':
-			task.Task.OperationTime = timings.TimeMultiplicationMS
-		case '/':
-			if b == 0 {
-				return 0, errors.ErrDivisionByZero
-			}
-			task.Task.OperationTime = timings.TimeDivisionMS
-		default:
-			return 0, errors.ErrUnknownOperation
-		}
-		select {
-		case <-r.task:
-			r.task <- task
-		default:
-			return 0, errors.ErrTaskChanIsFull
-		}
+// ResolveExpressionID finds the numeric ID behind an opaque UID.
+func (r *CalculatorRepository) ResolveExpressionID(uid string) (int, error) {
+	data, err := r.redis.Get(r.ctx, expressionUIDKey(uid))
+	if err != nil {
+		return 0, errors.ErrNotFound
+	}
+	return strconv.Atoi(data)
+}
 
-		for {
-			expr, err := r.GetExpressionByID(r.id)
-			if err != nil {
-				return 0, err
-			}
-			if expr.Expression.Status == statuses.StatusComplete {
-				r.mu.Lock()
+// resultCacheKey keeps single precision results apart from double ones, which
+// use the key they always had.
+func resultCacheKey(node *parser.Node, precision string) string {
+	key := hash.SHA256(parser.Canonical(node))
+	if precision != precisions.PrecisionDouble {
+		key = precision + ":" + key
+	}
+	return "result:" + key
+}
 
-				expr.Expression.Status = statuses.StatusProgress
-				err = r.SetExpression(*expr)
+func expressionData(id int, status string, user models.User, request models.Request) models.ExpressionData {
+	return models.ExpressionData{
+		ID:         id,
+		Status:     status,
+		UserID:     user.ID,
+		Expression: request.Expression,
+		Notation:   request.Notation,
+		Priority:   request.Priority,
+		Precision:  request.Precision,
+		Verify:     request.Verify,
+		ScheduleID: request.ScheduleID,
+		NonFinite:  request.AllowNonFinite,
+		Format:     formatOptions(request),
+	}
+}
 
-				r.mu.Unlock()
+func formatOptions(request models.Request) *format.Options {
+	if request.Options.IsZero() {
+		return nil
+	}
+	options := request.Options
+	return &options
+}
 
-				if err != nil {
-					return 0, err
-				}
+// priorityFor applies the default priority and caps it by the user's role.
+func priorityFor(user models.User, priority string) (string, error) {
+	if priority == "" {
+		return priorities.PriorityNormal, nil
+	}
+	if !priorities.Valid(priority) {
+		return "", fmt.Errorf("%w: unknown priority %q", errors.ErrInvalidRequest, priority)
+	}
 
-				result, err := r.GetResult()
-				if err != nil {
-					return 0, err
-				}
-				return result.Result, nil
-			}
-			time.Sleep(1 * time.Millisecond)
-		}
+	limit := priorities.PriorityNormal
+	if user.Role == roles.RoleAdmin {
+		limit = priorities.PriorityHigh
 	}
+	return priorities.Clamp(priority, limit), nil
+}
 
-	precedence := func(op byte) int {
-		switch op {
-		case '+', '-':
-			return 1
-		case '*', '/':
-			return 2
-		default:
-			return 0
-		}
+func (r *CalculatorRepository) timeout(request models.Request) time.Duration {
+	if request.TimeoutMS > 0 {
+		return time.Duration(request.TimeoutMS) * time.Millisecond
 	}
+	return r.cfg.DefaultTimeout
+}
 
-	applyTopOperation := func() error {
-		if len(ops) == 0 || len(values) < 2 {
-			return errors.ErrInvalidOperation
-		}
+func (r *CalculatorRepository) eta(node *parser.Node) int {
+	agents, err := r.LiveAgents()
+	if err != nil {
+		agents = 0
+	}
+	return parser.Estimate(node, agents)
+}
 
-		sec := values[len(values)-1]
-		fst := values[len(values)-2]
-		values = values[:len(values)-2]
+func (r *CalculatorRepository) fromCache(key string, user models.User, request models.Request, tasks int) (*models.Response, bool) {
+	if r.cfg.ResultCacheTTL <= 0 {
+		return nil, false
+	}
 
-		op := ops[len(ops)-1]
-		ops = ops[:len(ops)-1]
+	data, err := r.redis.Get(r.ctx, key)
+	if err != nil {
+		return nil, false
+	}
 
-		result, err := applyOperation(op, fst, sec)
-		if err != nil {
-			expr := models.Expression{Expression: models.ExpressionData{ID: r.id, Status: statuses.StatusError}}
-			err = r.SetExpression(expr)
-			if err != nil {
-				return err
-			}
-			return err
-		}
-		values = append(values, result)
-		return nil
+	result, err := strconv.ParseFloat(data, 64)
+	if err != nil {
+		return nil, false
 	}
 
-	for i := 0; i < len(expression); i++ {
-		ch := expression[i]
+	id, uid, err := r.nextID()
+	if err != nil {
+		return nil, false
+	}
 
-		if ch == ' ' {
-			continue
-		}
-		if unicode.IsDigit(rune(ch)) {
-			start := i
-			for i < len(expression) && (unicode.IsDigit(rune(expression[i])) || expression[i] == '.') {
-				i++
-			}
-			value, err := strconv.ParseFloat(expression[start:i], 64)
-			if err != nil {
-				return errors.ErrInvalidExpression
-			}
-			values = append(values, value)
-			i--
-		} else if ch == '(' {
-			ops = append(ops, ch)
-		} else if ch == ')' {
-			for len(ops) > 0 && ops[len(ops)-1] != '(' {
-				if err = applyTopOperation(); err != nil {
-					return err
-				}
-			}
-			if len(ops) == 0 {
-				return errors.ErrMismatchedParentheses
-			}
-			ops = ops[:len(ops)-1]
-		} else if ch == '+' || ch == '-' || ch == '*' || ch == '/' {
-			for len(ops) > 0 && precedence(ops[len(ops)-1]) >= precedence(ch) {
-				if err = applyTopOperation(); err != nil {
-					return err
-				}
-			}
-			ops = append(ops, ch)
-		} else {
-			return errors.ErrUnknownOperation
-		}
+	expr := models.Expression{Expression: expressionData(id, statuses.StatusComplete, user, request)}
+	expr.Expression.UID = uid
+	expr.Expression.Result = result
+	expr.Expression.Cached = true
+	expr.Expression.TasksTotal = tasks
+	expr.Expression.TasksDone = tasks
+	expr.Expression.Progress = 1
+	if err = r.SetExpression(expr); err != nil {
+		return nil, false
 	}
-	for len(ops) > 0 {
-		if err = applyTopOperation(); err != nil {
-			return err
+
+	log.Println("Cached id:", id)
+	return &models.Response{ID: id, UID: uid, Cached: true, Expression: &expr.Expression}, true
+}
+
+func (r *CalculatorRepository) Validate(user models.User, request models.Request) (*models.Validation, error) {
+	node, err := r.parse(user, request)
+	if err != nil {
+		if errors.Status(err) == http.StatusInternalServerError {
+			return nil, err
 		}
+		return &models.Validation{Valid: false, Error: errors.NewBody(err)}, nil
 	}
-	if len(values) != 1 {
-		return errors.ErrInvalidExpression
+
+	agents, err := r.LiveAgents()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	stats := parser.Analyze(node)
+
+	return &models.Validation{
+		Valid:          true,
+		AST:            node,
+		Operations:     stats.Operations,
+		TasksTotal:     stats.Tasks,
+		CriticalPath:   stats.CriticalPath,
+		CriticalPathMS: stats.CriticalPathMS,
+		LiveAgents:     agents,
+		EstimatedMS:    parser.Estimate(node, agents),
+	}, nil
+}
+
+func (r *CalculatorRepository) Convert(user models.User, request models.Request) (*models.Conversion, error) {
+	node, err := r.parse(user, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Conversion{
+		Infix:  parser.Canonical(node),
+		RPN:    parser.ToRPN(node),
+		Prefix: parser.ToPrefix(node),
+	}, nil
+}
+
+// touchAgent counts the agent as live. Only registered agents that fetch
+// tasks or answer the ones they were given are touched, so a request that
+// merely names an agent does not inflate the count.
+func (r *CalculatorRepository) touchAgent(agentID string) {
+	if agentID == "" {
+		return
+	}
+	if err := r.heartbeat(agentID); err != nil {
+		log.Println("Failed to touch agent:", err)
+	}
+}
+
+func (r *CalculatorRepository) heartbeat(agentID string) error {
+	now := time.Now()
+
+	err := r.redis.ZAdd(r.ctx, "agents:seen", float64(now.UnixMilli()), agentID)
+	if err != nil {
+		return err
+	}
+
+	if err = r.redis.Expire(r.ctx, agentKey(agentID), agentTTL); err != nil {
+		return err
+	}
+
+	return r.redis.ZRemRangeByScore(r.ctx, "agents:seen", "-inf", strconv.FormatInt(now.Add(-agentTTL).UnixMilli(), 10))
+}
+
+// LiveAgents counts the tasks live agents run at once: one per agent, or the
+// concurrency an agent registered with.
+func (r *CalculatorRepository) LiveAgents() (int, error) {
+	since := time.Now().Add(-agentTTL).UnixMilli()
+
+	count, err := r.redis.ZCount(r.ctx, "agents:seen", strconv.FormatInt(since, 10), "+inf")
+	if err != nil {
+		return 0, err
+	}
+
+	agents, err := r.liveCapabilities()
+	if err != nil {
+		return 0, err
+	}
+	for _, capabilities := range agents {
+		count += int64(max(capabilities.Concurrency, 1) - 1)
+	}
+
+	return int(count), nil
 }
 
 func (r *CalculatorRepository) GetAllExpressions() ([]models.Expression, error) {
@@ -316,51 +589,105 @@ func (r *CalculatorRepository) GetExpressionByID(id int) (*models.Expression, er
 		return nil, err
 	}
 
+	if !finished(expression.Expression.Status) {
+		expression.Expression.QueuePosition = r.queue.Position(id)
+		expression.Expression.Unschedulable = r.isUnschedulable(id)
+	}
+
 	return &expression, nil
 }
 
 func (r *CalculatorRepository) GetCurrentTask() (*models.Task, error) {
+	return r.queue.Peek()
+}
 
-	select {
-	case task := <-r.task:
-		if task.Task.ID == 0 && task.Task.OperationTime == 0 {
-			return nil, errors.ErrNotFound
-		}
-
-		r.task <- task
+func (r *CalculatorRepository) NextTask(agentID string) (*models.Task, error) {
+	capabilities, err := r.dispatchable(agentID)
+	if err != nil {
+		return nil, err
+	}
+	r.touchAgent(agentID)
+	return r.queue.NextFor(agentID, capabilities)
+}
 
-		return &task, nil
-	default:
-		return nil, errors.ErrNotAvailable
+func (r *CalculatorRepository) SetTaskResult(agentID string, result models.Result) error {
+	if err := r.queue.CompleteBy(agentID, result); err != nil {
+		return err
 	}
+	r.touchAgent(agentID)
+	return nil
 }
 
-func (r *CalculatorRepository) GetResult() (*models.Result, error) {
-	select {
-	case task := <-r.task:
-		id := task.Task.ID
-		if id == 0 {
-			return nil, errors.ErrNotFound
-		}
+// maxTaskBatch caps how many tasks one request hands out.
+const maxTaskBatch = 100
 
-		result := &models.Result{
-			ID: id,
-		}
+// NextTasks hands out up to limit tasks at once; an empty batch means the queue
+// has nothing for the agent.
+func (r *CalculatorRepository) NextTasks(agentID string, limit int) (*models.Tasks, error) {
+	capabilities, err := r.dispatchable(agentID)
+	if err != nil {
+		return nil, err
+	}
+	r.touchAgent(agentID)
 
-		expression, err := r.GetExpressionByID(id)
+	batch := &models.Tasks{Tasks: make([]models.TaskData, 0)}
+	for len(batch.Tasks) < min(limit, maxTaskBatch) {
+		task, err := r.queue.NextFor(agentID, capabilities)
+		if err == errors.ErrNotAvailable {
+			break
+		}
 		if err != nil {
+			if len(batch.Tasks) > 0 {
+				break
+			}
 			return nil, err
 		}
+		batch.Tasks = append(batch.Tasks, task.Task)
+	}
+	return batch, nil
+}
 
-		result.Result = expression.Expression.Result
-
-		r.task <- task
+// SetTaskResults completes every result of the batch on its own; a result
+// that is rejected does not affect the others.
+func (r *CalculatorRepository) SetTaskResults(agentID string, results []models.Result) (*models.ResultStatuses, error) {
+	reply := &models.ResultStatuses{Results: make([]models.ResultStatus, 0, len(results))}
+	accepted := false
+	for _, result := range results {
+		status := models.ResultStatus{ID: result.ID, Status: "accepted"}
+		if err := r.queue.CompleteBy(agentID, result); err != nil {
+			status.Status = "rejected"
+			status.Error = errors.NewBody(err)
+		} else {
+			accepted = true
+		}
+		reply.Results = append(reply.Results, status)
+	}
+	if accepted {
+		r.touchAgent(agentID)
+	}
+	return reply, nil
+}
 
-		return result, nil
-	default:
+func (r *CalculatorRepository) GetResult() (*models.Result, error) {
+	return r.queue.Last()
+}
 
-		return nil, errors.ErrNotAvailable
+func (r *CalculatorRepository) Metrics() (*models.Metrics, error) {
+	agents, err := r.liveCapabilities()
+	if err != nil {
+		return nil, err
 	}
+
+	r.mu.Lock()
+	held := len(r.held)
+	r.mu.Unlock()
+
+	return &models.Metrics{
+		Queue:         r.queue.Stats(),
+		Held:          held,
+		Agents:        agents,
+		Unschedulable: unschedulable(r.queue.Waiting(), agents),
+	}, nil
 }
 
 func (r *CalculatorRepository) SetExpression(expression models.Expression) error {
@@ -376,6 +703,13 @@ func (r *CalculatorRepository) SetExpression(expression models.Expression) error
 		return err
 	}
 
+	if expression.Expression.UID != "" {
+		err = r.redis.Set(r.ctx, expressionUIDKey(expression.Expression.UID), strconv.Itoa(expression.Expression.ID), 24*time.Hour)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = r.redis.SAdd(r.ctx, "expressions:all", strconv.Itoa(expression.Expression.ID))
 	return err
 }
@@ -398,35 +732,64 @@ func (r *CalculatorRepository) Register(login, password string) error {
 	return nil
 }
 
-func (r *CalculatorRepository) Login(login, password string) error {
+func (r *CalculatorRepository) Login(login, password string) (*models.User, error) {
 	var user models.User
 
-	query := `SELECT id, login, password FROM public.users WHERE login = $1;`
-	err := r.db.Db.QueryRow(query, login).Scan(&user.ID, &user.Login, &user.Password)
+	query := `SELECT id, login, password, role FROM public.users WHERE login = $1;`
+	err := r.db.Db.QueryRow(query, login).Scan(&user.ID, &user.Login, &user.Password, &user.Role)
 	if err != nil {
-		return errors.ErrInvalidCredentials
+		return nil, errors.ErrInvalidCredentials
 	}
 
 	success, err := hash.CheckStirngHash(password, user.Password)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	if !success {
-		return errors.ErrInvalidCredentials
+		return nil, errors.ErrInvalidCredentials
 	}
 
 	refreshToken, err := jwt.NewRefreshToken()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	encodedToken := base64.StdEncoding.EncodeToString([]byte(refreshToken))
 
 	query = `UPDATE public.users SET refresh_token = $1 WHERE id = $2;`
 	_, err = r.db.Db.Exec(query, encodedToken, user.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	return &user, nil
+}
+
+func (r *CalculatorRepository) SetUserRole(login, role string) error {
+	result, err := r.db.Db.Exec(`UPDATE public.users SET role = $1 WHERE login = $2;`, role, login)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errors.ErrNotFound
+	}
+	return nil
+}
+
+// userRole reads the current role of a user; requests without a user have
+// none.
+func (r *CalculatorRepository) userRole(userID int) (string, error) {
+	if userID == 0 {
+		return "", nil
+	}
+
+	var role string
+	err := r.db.Db.Get(&role, `SELECT role FROM public.users WHERE id = $1;`, userID)
+	if err == sql.ErrNoRows {
+		return "", errors.ErrNotFound
+	}
+	return role, err
 }
 
 func (r *CalculatorRepository) ValidateToken(token string) (int, error) {
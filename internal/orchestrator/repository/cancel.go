@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+)
+
+func finished(status string) bool {
+	return status != statuses.StatusPending && status != statuses.StatusProgress
+}
+
+func (r *CalculatorRepository) Cancel(user models.User, id int) (*models.Expression, error) {
+	expr, err := r.GetExpressionByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != roles.RoleAdmin && expr.Expression.UserID != user.ID {
+		return nil, errors.ErrForbidden
+	}
+	if finished(expr.Expression.Status) {
+		return nil, errors.ErrExpressionFinished
+	}
+
+	e := r.evaluation(id)
+	if e == nil && r.cluster != nil && r.cluster.stop(id, statuses.StatusCancelled) {
+		return r.awaitStopped(id)
+	}
+	if e == nil {
+		// Left unfinished by a previous orchestrator run, nothing to stop.
+		expr.Expression.Status = statuses.StatusCancelled
+		expr.Expression.EtaMS = 0
+		if err = r.SetExpression(*expr); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	e.stop(statuses.StatusCancelled)
+	<-e.done
+
+	expr, err = r.GetExpressionByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Expression.Status != statuses.StatusCancelled {
+		return nil, errors.ErrExpressionFinished
+	}
+	return expr, nil
+}
+
+// awaitStopped waits for another replica to write the final status of an
+// expression it was asked to stop.
+func (r *CalculatorRepository) awaitStopped(id int) (*models.Expression, error) {
+	deadline := time.Now().Add(callTimeout)
+	for time.Now().Before(deadline) {
+		expr, err := r.GetExpressionByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if finished(expr.Expression.Status) {
+			if expr.Expression.Status != statuses.StatusCancelled {
+				return nil, errors.ErrExpressionFinished
+			}
+			return expr, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, errors.ErrNoScheduler
+}
+
+func (r *CalculatorRepository) TaskStatus(taskID int) error {
+	return r.queue.Status(taskID)
+}
@@ -0,0 +1,750 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/uid"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// In cluster mode every replica accepts expressions and agent requests, while
+// the task queue itself runs on the replica holding the leader lock. Replicas
+// talk to it through Redis: requests go to the inbox list, answers and task
+// outcomes come back on the reply list of the asking replica, and dispatched
+// tasks wait for agents in a ready list per operation and precision, so that
+// an agent only takes tasks it is able to execute.
+const (
+	leaderKey = "cluster:leader"
+	epochKey  = "cluster:epoch"
+	inboxKey  = "cluster:inbox"
+	ownersKey = "cluster:owners"
+
+	leaderTTL      = 5 * time.Second
+	replicaTTL     = 5 * time.Second
+	clusterTick    = time.Second
+	callTimeout    = 2 * time.Second
+	viewTTL        = time.Second
+	recoveryPeriod = 5 * time.Second
+)
+
+const (
+	kindEnqueue  = "enqueue"
+	kindWithdraw = "withdraw"
+	kindStarted  = "started"
+	kindComplete = "complete"
+	kindStatus   = "status"
+	kindStats    = "stats"
+	kindWaiting  = "waiting"
+	kindLast     = "last"
+	kindRequeue  = "requeue"
+	kindDiscard  = "discard"
+	kindOutcome  = "outcome"
+	kindReply    = "reply"
+	kindStop     = "stop"
+)
+
+func replicaKey(id string) string {
+	return "cluster:replica:" + id
+}
+
+func replyKey(id string) string {
+	return "cluster:reply:" + id
+}
+
+func readyKey(operation, precision string) string {
+	return "cluster:ready:" + precision + ":" + operation
+}
+
+// readyKeys lists the ready lists holding tasks the capabilities allow.
+func readyKeys(capabilities models.Capabilities) []string {
+	keys := make([]string, 0, len(knownOperations)*len(knownPrecisions))
+	for _, precision := range knownPrecisions {
+		for _, operation := range knownOperations {
+			if capabilities.Supports(models.TaskData{Operation: operation, Precision: precision}) {
+				keys = append(keys, readyKey(operation, precision))
+			}
+		}
+	}
+	return keys
+}
+
+type clusterMessage struct {
+	Kind       string               `json:"kind"`
+	Request    string               `json:"request,omitempty"`
+	Replica    string               `json:"replica,omitempty"`
+	Owner      TaskOwner            `json:"owner"`
+	Task       models.TaskData      `json:"task"`
+	Result     models.Result        `json:"result"`
+	Agent      string               `json:"agent,omitempty"`
+	Source     string               `json:"source,omitempty"`
+	EnqueuedAt time.Time            `json:"enqueued_at"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt time.Time            `json:"finished_at"`
+	Attempts   []models.TaskAttempt `json:"attempts,omitempty"`
+	Value      int                  `json:"value,omitempty"`
+	Stats      *models.QueueStats   `json:"stats,omitempty"`
+	Waiting    []models.PendingTask `json:"waiting,omitempty"`
+	Positions  map[int]int          `json:"positions,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	Conflicts  []errors.Conflict    `json:"conflicts,omitempty"`
+}
+
+func (m clusterMessage) err() error {
+	if m.Error == "" {
+		return nil
+	}
+	return errors.FromCode(m.Error)
+}
+
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	return errors.Code(err)
+}
+
+func conflicts(err error) []errors.Conflict {
+	if verifyErr, ok := err.(*errors.VerificationError); ok {
+		return verifyErr.Conflicts
+	}
+	return nil
+}
+
+type enqueued struct {
+	message clusterMessage
+	done    chan TaskOutcome
+}
+
+type cluster struct {
+	r     *CalculatorRepository
+	id    string
+	seq   atomic.Int64
+	epoch int64
+
+	mu       sync.Mutex
+	enqueued map[string]*enqueued
+	requests map[<-chan TaskOutcome]string
+	calls    map[string]chan clusterMessage
+
+	leading context.CancelFunc
+
+	viewMu     sync.Mutex
+	view       queueView
+	refreshing bool
+}
+
+// queueView is a snapshot of the waiting tasks and queue positions on the
+// leader. Status requests read it instead of asking the leader each time.
+type queueView struct {
+	at        time.Time
+	waiting   []models.PendingTask
+	positions map[int]int
+}
+
+func newCluster(r *CalculatorRepository) *cluster {
+	id := r.cfg.ReplicaID
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "orchestrator"
+		}
+		id = hostname + "-" + uid.New()[:8]
+	}
+
+	return &cluster{
+		r:        r,
+		id:       id,
+		enqueued: make(map[string]*enqueued),
+		requests: make(map[<-chan TaskOutcome]string),
+		calls:    make(map[string]chan clusterMessage),
+	}
+}
+
+// run keeps the replica alive in Redis, competes for the leader lock and
+// routes replies until the repository context ends.
+func (c *cluster) run() {
+	go c.route()
+
+	ticker := time.NewTicker(clusterTick)
+	defer ticker.Stop()
+
+	for {
+		c.tick()
+
+		select {
+		case <-c.r.ctx.Done():
+			c.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *cluster) tick() {
+	ctx := c.r.ctx
+
+	if err := c.r.redis.Set(ctx, replicaKey(c.id), strconv.FormatInt(time.Now().Unix(), 10), replicaTTL); err != nil {
+		log.Println("Cluster heartbeat failed:", err)
+	}
+
+	held, err := c.r.redis.Lock(ctx, leaderKey, c.id, leaderTTL)
+	if err != nil {
+		log.Println("Cluster leader lock failed:", err)
+	}
+	switch {
+	case held && c.leading == nil:
+		leaderCtx, cancel := context.WithCancel(ctx)
+		c.leading = cancel
+		go c.lead(leaderCtx)
+	case !held && c.leading != nil:
+		log.Printf("Replica %s lost the scheduler lock", c.id)
+		c.leading()
+		c.leading = nil
+	}
+
+	// A new leader starts with an empty queue: hand it every task this
+	// replica still waits for.
+	data, err := c.r.redis.Get(ctx, epochKey)
+	if err != nil {
+		return
+	}
+	epoch, _ := strconv.ParseInt(data, 10, 64)
+	if epoch == c.epoch {
+		return
+	}
+	if c.epoch != 0 {
+		c.resend()
+	}
+	c.epoch = epoch
+}
+
+func (c *cluster) resign() {
+	if c.leading != nil {
+		c.leading()
+		c.leading = nil
+	}
+	ctx := context.Background()
+	c.r.redis.Unlock(ctx, leaderKey, c.id)
+	c.r.redis.Del(ctx, replicaKey(c.id))
+}
+
+func (c *cluster) resend() {
+	c.mu.Lock()
+	messages := make([]clusterMessage, 0, len(c.enqueued))
+	for _, e := range c.enqueued {
+		messages = append(messages, e.message)
+	}
+	c.mu.Unlock()
+
+	for _, message := range messages {
+		c.send(inboxKey, message)
+	}
+	if len(messages) > 0 {
+		log.Printf("Replica %s resent %d tasks to the new scheduler", c.id, len(messages))
+	}
+}
+
+func (c *cluster) send(key string, message clusterMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err = c.r.redis.RPush(c.r.ctx, key, string(data)); err != nil {
+		log.Println("Cluster message was not sent:", err)
+	}
+	return err
+}
+
+func (c *cluster) request() string {
+	return fmt.Sprintf("%s:%d", c.id, c.seq.Add(1))
+}
+
+// route delivers what arrives on the reply list of this replica.
+func (c *cluster) route() {
+	for c.r.ctx.Err() == nil {
+		data, err := c.r.redis.BLPop(c.r.ctx, clusterTick, replyKey(c.id))
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if c.r.ctx.Err() == nil {
+				log.Println("Cluster reply failed:", err)
+				time.Sleep(clusterTick)
+			}
+			continue
+		}
+
+		var message clusterMessage
+		if err = json.Unmarshal([]byte(data), &message); err != nil {
+			log.Println("Invalid cluster reply:", err)
+			continue
+		}
+
+		switch message.Kind {
+		case kindOutcome:
+			c.deliver(message)
+		case kindStop:
+			if e := c.r.evaluation(message.Value); e != nil {
+				e.stop(message.Source)
+			}
+		default:
+			c.mu.Lock()
+			reply, ok := c.calls[message.Request]
+			delete(c.calls, message.Request)
+			c.mu.Unlock()
+
+			if ok {
+				reply <- message
+			}
+		}
+	}
+}
+
+func (c *cluster) deliver(message clusterMessage) {
+	c.mu.Lock()
+	e, ok := c.enqueued[message.Request]
+	if ok {
+		delete(c.enqueued, message.Request)
+		delete(c.requests, e.done)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	outcome := TaskOutcome{
+		Result:     message.Result.Result,
+		TaskID:     message.Result.ID,
+		Agent:      message.Agent,
+		Source:     message.Source,
+		EnqueuedAt: message.EnqueuedAt,
+		StartedAt:  message.StartedAt,
+		FinishedAt: message.FinishedAt,
+		Attempts:   message.Attempts,
+	}
+	if message.Result.Error != "" {
+		outcome.Err = errors.FromCode(message.Result.Error)
+	}
+	if len(message.Conflicts) > 0 {
+		outcome.Err = &errors.VerificationError{Conflicts: message.Conflicts}
+	}
+	e.done <- outcome
+}
+
+// call asks the leader and waits for its answer.
+func (c *cluster) call(message clusterMessage) (clusterMessage, error) {
+	message.Request = c.request()
+	message.Replica = c.id
+
+	reply := make(chan clusterMessage, 1)
+	c.mu.Lock()
+	c.calls[message.Request] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, message.Request)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(inboxKey, message); err != nil {
+		return clusterMessage{}, errors.ErrNoScheduler
+	}
+
+	select {
+	case answer := <-reply:
+		return answer, nil
+	case <-time.After(callTimeout):
+		return clusterMessage{}, errors.ErrNoScheduler
+	}
+}
+
+func (c *cluster) EnqueueFor(owner TaskOwner, arg1, arg2 float64, operation string) <-chan TaskOutcome {
+	message := clusterMessage{
+		Kind:    kindEnqueue,
+		Request: c.request(),
+		Replica: c.id,
+		Owner:   owner,
+		Task:    models.TaskData{Arg1: arg1, Arg2: arg2, Operation: operation},
+	}
+
+	done := make(chan TaskOutcome, 1)
+	c.mu.Lock()
+	c.enqueued[message.Request] = &enqueued{message: message, done: done}
+	c.requests[done] = message.Request
+	c.mu.Unlock()
+
+	c.send(inboxKey, message)
+	return done
+}
+
+func (c *cluster) Withdraw(done <-chan TaskOutcome) {
+	c.mu.Lock()
+	request, ok := c.requests[done]
+	if ok {
+		delete(c.requests, done)
+		delete(c.enqueued, request)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.send(inboxKey, clusterMessage{Kind: kindWithdraw, Request: request})
+	}
+}
+
+// NextFor takes a task from the ready lists the agent can serve, trying them
+// in random order so that none of them starves.
+func (c *cluster) NextFor(agentID string, capabilities models.Capabilities) (*models.Task, error) {
+	keys := readyKeys(capabilities)
+
+	var (
+		data string
+		err  error = errors.ErrNotAvailable
+	)
+	for _, i := range rand.Perm(len(keys)) {
+		if data, err = c.r.redis.LPop(c.r.ctx, keys[i]); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.ErrNotAvailable
+	}
+
+	var task models.Task
+	if err = json.Unmarshal([]byte(data), &task); err != nil {
+		return nil, err
+	}
+
+	c.send(inboxKey, clusterMessage{Kind: kindStarted, Task: task.Task, Agent: agentID})
+	return &task, nil
+}
+
+func (c *cluster) Peek() (*models.Task, error) {
+	var (
+		data string
+		err  error = errors.ErrNotAvailable
+	)
+	for _, key := range readyKeys(models.Capabilities{}) {
+		if data, err = c.r.redis.LIndex(c.r.ctx, key, 0); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.ErrNotAvailable
+	}
+
+	var task models.Task
+	if err = json.Unmarshal([]byte(data), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (c *cluster) CompleteBy(agentID string, result models.Result) error {
+	reply, err := c.call(clusterMessage{Kind: kindComplete, Result: result, Agent: agentID})
+	if err != nil {
+		return err
+	}
+	return reply.err()
+}
+
+func (c *cluster) Status(id int) error {
+	reply, err := c.call(clusterMessage{Kind: kindStatus, Task: models.TaskData{ID: id}})
+	if err != nil {
+		// Keep the agent working while the scheduler is away.
+		return nil
+	}
+	return reply.err()
+}
+
+func (c *cluster) Position(expression int) int {
+	return c.queueView().positions[expression]
+}
+
+func (c *cluster) Stats() models.QueueStats {
+	reply, err := c.call(clusterMessage{Kind: kindStats})
+	if err != nil || reply.Stats == nil {
+		return models.QueueStats{}
+	}
+	return *reply.Stats
+}
+
+func (c *cluster) Waiting() []models.PendingTask {
+	return c.queueView().waiting
+}
+
+// queueView returns the last snapshot of the leader queue and refreshes it in
+// the background once it is older than viewTTL, so that a slow or missing
+// leader never holds up a request.
+func (c *cluster) queueView() queueView {
+	c.viewMu.Lock()
+	defer c.viewMu.Unlock()
+
+	if time.Since(c.view.at) > viewTTL && !c.refreshing {
+		c.refreshing = true
+		go c.refreshView()
+	}
+	return c.view
+}
+
+func (c *cluster) refreshView() {
+	reply, err := c.call(clusterMessage{Kind: kindWaiting})
+
+	c.viewMu.Lock()
+	defer c.viewMu.Unlock()
+
+	c.refreshing = false
+	c.view = queueView{at: time.Now()}
+	if err == nil {
+		c.view.waiting = reply.Waiting
+		c.view.positions = reply.Positions
+	}
+}
+
+func (c *cluster) Last() (*models.Result, error) {
+	reply, err := c.call(clusterMessage{Kind: kindLast})
+	if err != nil {
+		return nil, err
+	}
+	if err = reply.err(); err != nil {
+		return nil, err
+	}
+	return &reply.Result, nil
+}
+
+func (c *cluster) Requeue(id int) error {
+	reply, err := c.call(clusterMessage{Kind: kindRequeue, Task: models.TaskData{ID: id}})
+	if err != nil {
+		return err
+	}
+	return reply.err()
+}
+
+func (c *cluster) Discard(id int) error {
+	reply, err := c.call(clusterMessage{Kind: kindDiscard, Task: models.TaskData{ID: id}})
+	if err != nil {
+		return err
+	}
+	return reply.err()
+}
+
+// claim records this replica as the one evaluating the expression.
+func (c *cluster) claim(id int) {
+	if err := c.r.redis.HSet(c.r.ctx, ownersKey, strconv.Itoa(id), c.id); err != nil {
+		log.Println("Failed to claim expression:", err)
+	}
+}
+
+func (c *cluster) release(id int) {
+	if err := c.r.redis.HDel(c.r.ctx, ownersKey, strconv.Itoa(id)); err != nil {
+		log.Println("Failed to release expression:", err)
+	}
+}
+
+// stop asks the replica evaluating the expression to stop it. It reports
+// false when no live replica owns the expression.
+func (c *cluster) stop(id int, reason string) bool {
+	owner, err := c.r.redis.HGet(c.r.ctx, ownersKey, strconv.Itoa(id))
+	if err != nil || owner == c.id {
+		return false
+	}
+	if alive, err := c.r.redis.Exists(c.r.ctx, replicaKey(owner)); err != nil || !alive {
+		return false
+	}
+
+	return c.send(replyKey(owner), clusterMessage{Kind: kindStop, Value: id, Source: reason}) == nil
+}
+
+// leader runs the task queue for the whole cluster while this replica holds
+// the lock.
+type leader struct {
+	c        *cluster
+	queue    *TaskQueue
+	mu       sync.Mutex
+	requests map[string]<-chan TaskOutcome
+}
+
+func (c *cluster) lead(ctx context.Context) {
+	epoch, err := c.r.redis.Incr(ctx, epochKey)
+	if err != nil {
+		log.Println("Failed to start the scheduler:", err)
+		return
+	}
+	log.Printf("Replica %s leads the scheduler, epoch %d", c.id, epoch)
+
+	// Tasks dispatched by the previous leader are gone with its queue.
+	c.r.redis.Del(ctx, readyKeys(models.Capabilities{})...)
+
+	l := &leader{c: c, queue: c.r.newQueue(), requests: make(map[string]<-chan TaskOutcome)}
+
+	var reaped, recovered time.Time
+	for ctx.Err() == nil {
+		data, err := c.r.redis.BLPop(ctx, clusterTick, inboxKey)
+		switch {
+		case err == nil:
+			var message clusterMessage
+			if err = json.Unmarshal([]byte(data), &message); err != nil {
+				log.Println("Invalid cluster message:", err)
+				break
+			}
+			l.handle(ctx, message)
+		case err != redis.Nil && ctx.Err() == nil:
+			log.Println("Cluster inbox failed:", err)
+			time.Sleep(clusterTick)
+		}
+
+		l.fill(ctx)
+
+		now := time.Now()
+		if now.Sub(reaped) >= clusterTick && c.r.cfg.TaskLeaseTimeout > 0 {
+			l.queue.Reap(now)
+			reaped = now
+		}
+		if now.Sub(recovered) >= recoveryPeriod {
+			c.r.recoverOrphans()
+			recovered = now
+		}
+	}
+}
+
+func (l *leader) handle(ctx context.Context, message clusterMessage) {
+	reply := clusterMessage{Kind: kindReply, Request: message.Request}
+
+	switch message.Kind {
+	case kindEnqueue:
+		l.enqueue(ctx, message)
+		return
+	case kindWithdraw:
+		l.mu.Lock()
+		done, ok := l.requests[message.Request]
+		delete(l.requests, message.Request)
+		l.mu.Unlock()
+
+		if ok {
+			l.queue.Withdraw(done)
+		}
+		return
+	case kindStarted:
+		l.queue.Assign(message.Task.ID, message.Agent)
+		return
+	case kindComplete:
+		reply.Error = errorCode(l.queue.CompleteBy(message.Agent, message.Result))
+	case kindStatus:
+		reply.Error = errorCode(l.queue.Status(message.Task.ID))
+	case kindStats:
+		stats := l.queue.Stats()
+		reply.Stats = &stats
+	case kindWaiting:
+		reply.Waiting = l.queue.Waiting()
+		reply.Positions = l.queue.Positions()
+	case kindLast:
+		result, err := l.queue.Last()
+		reply.Error = errorCode(err)
+		if result != nil {
+			reply.Result = *result
+		}
+	case kindRequeue:
+		reply.Error = errorCode(l.queue.Requeue(message.Task.ID))
+	case kindDiscard:
+		reply.Error = errorCode(l.queue.Discard(message.Task.ID))
+	default:
+		log.Println("Unknown cluster message:", message.Kind)
+		return
+	}
+
+	l.c.send(replyKey(message.Replica), reply)
+}
+
+func (l *leader) enqueue(ctx context.Context, message clusterMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A replica resends its tasks to every new leader; the inbox may still
+	// hold the first copy.
+	if _, ok := l.requests[message.Request]; ok {
+		return
+	}
+
+	task := message.Task
+	done := l.queue.EnqueueFor(message.Owner, task.Arg1, task.Arg2, task.Operation)
+	l.requests[message.Request] = done
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case outcome := <-done:
+			l.mu.Lock()
+			delete(l.requests, message.Request)
+			l.mu.Unlock()
+
+			l.c.send(replyKey(message.Replica), clusterMessage{
+				Kind:       kindOutcome,
+				Request:    message.Request,
+				Result:     models.Result{ID: outcome.TaskID, Result: outcome.Result, Error: errorCode(outcome.Err)},
+				Agent:      outcome.Agent,
+				Source:     outcome.Source,
+				EnqueuedAt: outcome.EnqueuedAt,
+				StartedAt:  outcome.StartedAt,
+				FinishedAt: outcome.FinishedAt,
+				Attempts:   outcome.Attempts,
+				Conflicts:  conflicts(outcome.Err),
+			})
+		}
+	}()
+}
+
+// fill keeps up to ReadyDepth tasks of every operation and precision waiting
+// for agents; the rest stays in the fair queue so that urgent tasks can still
+// overtake it. Copies of straggling tasks go to the ready lists as well.
+func (l *leader) fill(ctx context.Context) {
+	if l.queue.Stats().Pending == 0 && !l.queue.Straggling(time.Now()) {
+		return
+	}
+
+	for _, precision := range knownPrecisions {
+		for _, operation := range knownOperations {
+			capabilities := models.Capabilities{Operations: []string{operation}, Precisions: []string{precision}}
+			l.fillReady(ctx, readyKey(operation, precision), capabilities)
+		}
+	}
+}
+
+func (l *leader) fillReady(ctx context.Context, key string, capabilities models.Capabilities) {
+	depth := int64(max(l.c.r.cfg.ReadyDepth, 1))
+
+	ready, err := l.c.r.redis.LLen(ctx, key)
+	if err != nil {
+		return
+	}
+
+	for ; ready < depth; ready++ {
+		task, err := l.queue.DispatchFor(capabilities)
+		if err != nil {
+			return
+		}
+
+		data, err := json.Marshal(task)
+		if err == nil {
+			err = l.c.r.redis.RPush(ctx, key, string(data))
+		}
+		if err != nil {
+			log.Println("Failed to dispatch task:", err)
+			l.queue.Undispatch(task.Task.ID)
+			return
+		}
+	}
+}
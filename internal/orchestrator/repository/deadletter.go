@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	deadLettersKey  = "deadletters:all"
+	deadLetterIDKey = "deadletters:id"
+)
+
+func deadLetterKey(id int) string {
+	return fmt.Sprintf("deadletter:%d", id)
+}
+
+func (r *CalculatorRepository) storeDeadLetter(letter models.DeadLetter) {
+	id, err := r.redis.Incr(r.ctx, deadLetterIDKey)
+	if err != nil {
+		log.Println("Failed to store dead letter:", err)
+		return
+	}
+	letter.ID = int(id)
+
+	data, err := json.Marshal(letter)
+	if err != nil {
+		log.Println("Failed to store dead letter:", err)
+		return
+	}
+
+	if err = r.redis.Set(r.ctx, deadLetterKey(letter.ID), string(data), r.cfg.DeadLetterTTL); err != nil {
+		log.Println("Failed to store dead letter:", err)
+		return
+	}
+	if err = r.redis.SAdd(r.ctx, deadLettersKey, strconv.Itoa(letter.ID)); err != nil {
+		log.Println("Failed to store dead letter:", err)
+	}
+	log.Printf("Task %d moved to dead letters as %d after %d attempts: %s", letter.Task.ID, letter.ID, letter.Attempts, letter.Error)
+}
+
+func (r *CalculatorRepository) ListDeadLetters() (*models.DeadLetters, error) {
+	ids, err := r.redis.SMembers(r.ctx, deadLettersKey)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := &models.DeadLetters{DeadLetters: make([]models.DeadLetter, 0, len(ids))}
+	for _, idStr := range ids {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		// Letters expire after DEAD_LETTER_TTL; their ids go with them.
+		letter, err := r.GetDeadLetter(id)
+		if err == errors.ErrNotFound {
+			if err = r.redis.SRem(r.ctx, deadLettersKey, idStr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters.DeadLetters = append(letters.DeadLetters, *letter)
+	}
+
+	sort.Slice(letters.DeadLetters, func(i, j int) bool {
+		return letters.DeadLetters[i].ID < letters.DeadLetters[j].ID
+	})
+	return letters, nil
+}
+
+func (r *CalculatorRepository) GetDeadLetter(id int) (*models.DeadLetter, error) {
+	data, err := r.redis.Get(r.ctx, deadLetterKey(id))
+	if err != nil {
+		return nil, errors.ErrNotFound
+	}
+
+	var letter models.DeadLetter
+	if err = json.Unmarshal([]byte(data), &letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// RequeueDeadLetter puts the task back in the queue for the expressions still
+// waiting for it. A letter that no expression waits for anymore, after a
+// restart or a cancel, is deleted.
+func (r *CalculatorRepository) RequeueDeadLetter(id int) (*models.DeadLetter, error) {
+	letter, err := r.GetDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.queue.Requeue(letter.Task.ID)
+	if err == errors.ErrNotFound {
+		if err = r.deleteDeadLetter(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: task %d", errors.ErrDeadLetterGone, letter.Task.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return letter, r.deleteDeadLetter(id)
+}
+
+// DiscardDeadLetter fails the expressions waiting for the task with its last
+// error and forgets the dead letter.
+func (r *CalculatorRepository) DiscardDeadLetter(id int) (*models.DeadLetter, error) {
+	letter, err := r.GetDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gone already when no expression waits for the task anymore.
+	r.queue.Discard(letter.Task.ID)
+
+	return letter, r.deleteDeadLetter(id)
+}
+
+func (r *CalculatorRepository) deleteDeadLetter(id int) error {
+	if err := r.redis.Del(r.ctx, deadLetterKey(id)); err != nil {
+		return err
+	}
+	return r.redis.SRem(r.ctx, deadLettersKey, strconv.Itoa(id))
+}
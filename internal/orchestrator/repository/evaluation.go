@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+)
+
+type evaluation struct {
+	id       int
+	uid      string
+	user     models.User
+	request  models.Request
+	node     *parser.Node
+	cacheKey string
+	tasks    int
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	submitted time.Time
+	timeout   time.Duration
+	timer     *time.Timer
+	held      *heldExpression
+	reserved  int
+
+	mu     sync.Mutex
+	expr   models.Expression
+	values map[*parser.Node]float64
+	steps  int
+	traced map[*parser.Node]int
+	reason string
+}
+
+// newEvaluation registers the evaluation so it can be stopped from the moment
+// its id is handed out; the deadline is counted from submission too.
+func (r *CalculatorRepository) newEvaluation(id int, user models.User, request models.Request, node *parser.Node, cacheKey string, tasks int, timeout time.Duration) *evaluation {
+	ctx, cancel := context.WithCancel(r.ctx)
+	e := &evaluation{
+		id:        id,
+		user:      user,
+		request:   request,
+		node:      node,
+		cacheKey:  cacheKey,
+		tasks:     tasks,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		submitted: time.Now(),
+		timeout:   timeout,
+	}
+
+	if timeout > 0 {
+		e.timer = time.AfterFunc(timeout, func() {
+			e.stop(statuses.StatusTimeout)
+		})
+	}
+
+	r.mu.Lock()
+	r.evals[id] = e
+	r.mu.Unlock()
+
+	if r.cluster != nil {
+		r.cluster.claim(id)
+	}
+	return e
+}
+
+func (r *CalculatorRepository) unregister(e *evaluation) {
+	r.mu.Lock()
+	delete(r.evals, e.id)
+	r.mu.Unlock()
+
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.cancel()
+
+	if r.cluster != nil {
+		r.cluster.release(e.id)
+	}
+}
+
+func (e *evaluation) owner() TaskOwner {
+	return TaskOwner{
+		Expression: e.id,
+		User:       e.user.ID,
+		Priority:   e.request.Priority,
+		Precision:  e.request.Precision,
+		Verify:     e.request.Verify,
+	}
+}
+
+func (e *evaluation) deadline() *time.Time {
+	if e.timeout <= 0 {
+		return nil
+	}
+	deadline := e.submitted.Add(e.timeout)
+	return &deadline
+}
+
+func (r *CalculatorRepository) evaluation(id int) *evaluation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.evals[id]
+}
+
+// stop cancels the evaluation; the first reason given becomes its final
+// status.
+func (e *evaluation) stop(reason string) {
+	e.mu.Lock()
+	if e.reason == "" {
+		e.reason = reason
+	}
+	e.mu.Unlock()
+
+	e.cancel()
+}
+
+func (r *CalculatorRepository) run(e *evaluation) {
+	defer close(e.done)
+	defer r.unregister(e)
+
+	ctx := e.ctx
+
+	if err := r.awaitAdmission(ctx, e); err != nil {
+		r.stopHeld(e)
+		return
+	}
+	r.mu.Lock()
+	e.reserved = e.tasks
+	r.mu.Unlock()
+	defer r.releaseReserved(e)
+
+	e.expr = models.Expression{Expression: expressionData(e.id, statuses.StatusProgress, e.user, e.request)}
+	e.expr.Expression.UID = e.uid
+	e.expr.Expression.TasksTotal = e.tasks
+	e.expr.Expression.EtaMS = r.eta(e.node)
+	e.expr.Expression.Deadline = e.deadline()
+	if err := r.SetExpression(e.expr); err != nil {
+		log.Println("Failed id:", e.id, err)
+		return
+	}
+
+	result, err := r.evaluate(ctx, e, e.node)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	expr := e.expr
+	expr.Expression.EtaMS = 0
+	expr.Expression.ElapsedMS = time.Since(e.submitted).Milliseconds()
+	if err != nil && e.reason != "" {
+		log.Println("Stopped id:", e.id, e.reason)
+		expr.Expression.Status = e.reason
+		if e.reason == statuses.StatusTimeout {
+			expr.Expression.Error = errors.NewBody(errors.ErrDeadlineExceeded)
+		}
+		if err = r.SetExpression(expr); err != nil {
+			log.Println("Failed to save id:", e.id, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Println("Failed id:", e.id, err)
+		expr.Expression.Status = statuses.StatusError
+		expr.Expression.Error = errors.NewBody(err)
+		if err = r.SetExpression(expr); err != nil {
+			log.Println("Failed to save id:", e.id, err)
+		}
+		return
+	}
+
+	expr.Expression.Status = statuses.StatusComplete
+	expr.Expression.Result = result
+	expr.Expression.TasksDone = e.tasks
+	expr.Expression.Progress = 1
+	if err = r.SetExpression(expr); err != nil {
+		log.Println("Failed to save id:", e.id, err)
+		return
+	}
+	log.Println("Passed id:", e.id)
+
+	if r.cfg.ResultCacheTTL > 0 && isFinite(result) {
+		value := strconv.FormatFloat(result, 'g', -1, 64)
+		if err = r.redis.Set(r.ctx, e.cacheKey, value, r.cfg.ResultCacheTTL); err != nil {
+			log.Println("Failed to cache result:", err)
+		}
+	}
+}
+
+func (r *CalculatorRepository) evaluate(ctx context.Context, e *evaluation, node *parser.Node) (float64, error) {
+	switch node.Type {
+	case parser.TypeNumber:
+		return node.Value, nil
+	case parser.TypeUnary:
+		value, err := r.evaluate(ctx, e, node.Operand)
+		if err != nil {
+			return 0, err
+		}
+		r.recordStep(e, node, -value)
+		return -value, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		fst, sec float64
+		rightErr error
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sec, rightErr = r.evaluate(ctx, e, node.Right)
+		if rightErr != nil {
+			cancel()
+		}
+	}()
+
+	fst, err := r.evaluate(ctx, e, node.Left)
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	if err != nil {
+		return 0, err
+	}
+	if rightErr != nil {
+		return 0, rightErr
+	}
+
+	if node.Token == "/" && sec == 0 {
+		return 0, errors.NewExpressionError(errors.ErrDivisionByZero, node.Offset, node.Token)
+	}
+
+	done := r.queue.EnqueueFor(e.owner(), fst, sec, node.Token)
+
+	select {
+	case outcome := <-done:
+		r.taskFinished(e)
+		r.recordTrace(e, node, fst, sec, outcome)
+		result, err := e.accept(outcome)
+		if err != nil {
+			return 0, errors.NewExpressionError(err, node.Offset, node.Token)
+		}
+		r.recordStep(e, node, result)
+		r.updateProgress(e)
+		return result, nil
+	case <-ctx.Done():
+		r.queue.Withdraw(done)
+		return 0, ctx.Err()
+	}
+}
+
+// updateProgress saves the finished task count and the estimated time left
+// for the tasks that have not finished yet.
+func (r *CalculatorRepository) updateProgress(e *evaluation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	data := &e.expr.Expression
+	data.TasksDone = len(e.traced)
+	if e.tasks > 0 {
+		data.Progress = float64(data.TasksDone) / float64(e.tasks)
+	}
+	data.EtaMS = r.eta(parser.Substitute(e.node, e.values))
+
+	if err := r.SetExpression(e.expr); err != nil {
+		log.Println("Failed to save progress id:", e.id, err)
+	}
+}
+
+// accept checks a task outcome against the expression options: agents report
+// non-finite values as OVERFLOW or NAN, which only opted-in expressions keep.
+func (e *evaluation) accept(outcome TaskOutcome) (float64, error) {
+	err := outcome.Err
+	if err == nil {
+		err = checkFinite(outcome.Result)
+	}
+
+	if err != nil && e.request.AllowNonFinite && (err == errors.ErrOverflow || err == errors.ErrNaN) {
+		return outcome.Result, nil
+	}
+	return outcome.Result, err
+}
+
+func isFinite(value float64) bool {
+	return !math.IsInf(value, 0) && !math.IsNaN(value)
+}
+
+func checkFinite(value float64) error {
+	switch {
+	case math.IsNaN(value):
+		return errors.ErrNaN
+	case math.IsInf(value, 0):
+		return errors.ErrOverflow
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+
+	"github.com/lib/pq"
+)
+
+func (cfg CalculatorRepositoryConfig) limits() models.Limits {
+	return models.Limits{
+		MaxLength:      cfg.MaxExpressionLength,
+		MaxDepth:       cfg.MaxDepth,
+		MaxTasks:       cfg.MaxTasks,
+		MaxEstimatedMS: cfg.MaxEstimatedMS,
+	}
+}
+
+func (r *CalculatorRepository) limitsFor(userID int) (models.Limits, error) {
+	limits := r.cfg.limits()
+	if userID == 0 {
+		return limits, nil
+	}
+
+	override, err := r.GetUserLimits(userID)
+	if err == errors.ErrNotFound {
+		return limits, nil
+	}
+	if err != nil {
+		return limits, err
+	}
+
+	if override.MaxLength.Valid {
+		limits.MaxLength = override.MaxLength.Int
+	}
+	if override.MaxDepth.Valid {
+		limits.MaxDepth = override.MaxDepth.Int
+	}
+	if override.MaxTasks.Valid {
+		limits.MaxTasks = override.MaxTasks.Int
+	}
+	if override.MaxEstimatedMS.Valid {
+		limits.MaxEstimatedMS = override.MaxEstimatedMS.Int
+	}
+
+	return limits, nil
+}
+
+func checkLength(limits models.Limits, expression string) error {
+	if limits.MaxLength > 0 && len(expression) > limits.MaxLength {
+		return fmt.Errorf("%w: %d characters, limit is %d", errors.ErrExpressionTooLong, len(expression), limits.MaxLength)
+	}
+	return nil
+}
+
+// parseInput parses the expression only once it is known to be within the
+// length limit, and stops the parser at the depth limit instead of building
+// the whole tree first.
+func parseInput(limits models.Limits, expression, notation string) (*parser.Node, error) {
+	if err := checkLength(limits, expression); err != nil {
+		return nil, err
+	}
+	return parser.ParseNotationDepth(expression, notation, limits.MaxDepth)
+}
+
+func (r *CalculatorRepository) checkLimits(limits models.Limits, node *parser.Node) error {
+	stats := parser.Analyze(node)
+
+	if limits.MaxDepth > 0 && stats.Depth > limits.MaxDepth {
+		return fmt.Errorf("%w: depth %d, limit is %d", errors.ErrExpressionTooDeep, stats.Depth, limits.MaxDepth)
+	}
+	if limits.MaxTasks > 0 && stats.Tasks > limits.MaxTasks {
+		return fmt.Errorf("%w: %d tasks, limit is %d", errors.ErrTooManyTasks, stats.Tasks, limits.MaxTasks)
+	}
+
+	if limits.MaxEstimatedMS > 0 {
+		agents, err := r.LiveAgents()
+		if err != nil {
+			return err
+		}
+		if estimate := parser.Estimate(node, agents); estimate > limits.MaxEstimatedMS {
+			return fmt.Errorf("%w: estimated %d ms, limit is %d ms", errors.ErrEstimateTooLong, estimate, limits.MaxEstimatedMS)
+		}
+	}
+
+	return nil
+}
+
+func (r *CalculatorRepository) GetUserLimits(userID int) (*models.UserLimits, error) {
+	var limits models.UserLimits
+
+	query := `SELECT user_id, max_length, max_depth, max_tasks, max_estimated_ms FROM public.user_limits WHERE user_id = $1;`
+	err := r.db.Db.Get(&limits, query, userID)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &limits, nil
+}
+
+func (r *CalculatorRepository) SetUserLimits(limits models.UserLimits) error {
+	query := `INSERT INTO public.user_limits (user_id, max_length, max_depth, max_tasks, max_estimated_ms)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			max_length = EXCLUDED.max_length,
+			max_depth = EXCLUDED.max_depth,
+			max_tasks = EXCLUDED.max_tasks,
+			max_estimated_ms = EXCLUDED.max_estimated_ms;`
+	_, err := r.db.Db.Exec(query, limits.UserID, limits.MaxLength, limits.MaxDepth, limits.MaxTasks, limits.MaxEstimatedMS)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == "23503" {
+			return errors.ErrNotFound
+		}
+	}
+	return err
+}
+
+func (r *CalculatorRepository) DeleteUserLimits(userID int) error {
+	result, err := r.db.Db.Exec(`DELETE FROM public.user_limits WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errors.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const disagreementsKey = "agents:disagreements"
+
+// recordDisagreement counts a disagreement against every outlier agent; an
+// agent reaching QUARANTINE_THRESHOLD gets no more tasks until released.
+func (r *CalculatorRepository) recordDisagreement(disagreement models.Disagreement) {
+	log.Printf("Agents disagreed on task %d of expressions %v: %+v", disagreement.Task.ID, disagreement.Expressions, disagreement.Results)
+
+	for _, agentID := range disagreement.Outliers {
+		if agentID == "" {
+			continue
+		}
+		score, err := r.redis.HIncrBy(r.ctx, disagreementsKey, agentID, 1)
+		if err != nil {
+			log.Println("Failed to record disagreement:", err)
+			continue
+		}
+		if r.quarantined(int(score)) {
+			log.Printf("Agent %s is quarantined after %d disagreements", agentID, score)
+		}
+	}
+}
+
+// disagreements returns the disagreement score of every agent that has one.
+func (r *CalculatorRepository) disagreements() (map[string]int, error) {
+	values, err := r.redis.HGetAll(r.ctx, disagreementsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]int, len(values))
+	for agentID, value := range values {
+		score, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		scores[agentID] = score
+	}
+	return scores, nil
+}
+
+func (r *CalculatorRepository) quarantined(score int) bool {
+	return r.cfg.QuarantineThreshold > 0 && score >= r.cfg.QuarantineThreshold
+}
+
+// ListAgents returns the live agents together with every agent that has
+// disagreed with others, quarantined ones included.
+func (r *CalculatorRepository) ListAgents() (*models.Agents, error) {
+	scores, err := r.disagreements()
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := r.liveCapabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(live)+len(scores))
+	for agentID := range live {
+		ids = append(ids, agentID)
+	}
+	for agentID := range scores {
+		if _, ok := live[agentID]; !ok {
+			ids = append(ids, agentID)
+		}
+	}
+	sort.Strings(ids)
+
+	agents := &models.Agents{Agents: make([]models.AgentInfo, 0, len(ids))}
+	for _, agentID := range ids {
+		info, err := r.agentInfo(agentID, scores[agentID])
+		if err != nil {
+			return nil, err
+		}
+		agents.Agents = append(agents.Agents, *info)
+	}
+	return agents, nil
+}
+
+// ReleaseAgent clears the disagreement score of an agent, taking it out of
+// quarantine.
+func (r *CalculatorRepository) ReleaseAgent(agentID string) (*models.AgentInfo, error) {
+	scores, err := r.disagreements()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := scores[agentID]; !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	if err = r.redis.HDel(r.ctx, disagreementsKey, agentID); err != nil {
+		return nil, err
+	}
+	log.Printf("Agent %s is released from quarantine", agentID)
+	return r.agentInfo(agentID, 0)
+}
+
+func (r *CalculatorRepository) agentInfo(agentID string, score int) (*models.AgentInfo, error) {
+	info := &models.AgentInfo{ID: agentID, Disagreements: score, Quarantined: r.quarantined(score)}
+
+	capabilities, err := r.agentCapabilities(agentID)
+	if err == errors.ErrAgentNotRegistered {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Capabilities = &capabilities
+	return info, nil
+}
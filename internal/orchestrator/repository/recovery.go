@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+)
+
+// recoverOrphans restarts expressions whose replica stopped sending
+// heartbeats. They are evaluated again from their stored text.
+func (r *CalculatorRepository) recoverOrphans() {
+	owners, err := r.redis.HGetAll(r.ctx, ownersKey)
+	if err != nil {
+		log.Println("Failed to list expression owners:", err)
+		return
+	}
+
+	alive := make(map[string]bool)
+	for idStr, owner := range owners {
+		live, ok := alive[owner]
+		if !ok {
+			live, err = r.redis.Exists(r.ctx, replicaKey(owner))
+			if err != nil {
+				return
+			}
+			alive[owner] = live
+		}
+		if live {
+			continue
+		}
+
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			r.redis.HDel(r.ctx, ownersKey, idStr)
+			continue
+		}
+		r.resume(id, owner)
+	}
+}
+
+func (r *CalculatorRepository) resume(id int, owner string) {
+	expr, err := r.GetExpressionByID(id)
+	if err != nil || finished(expr.Expression.Status) || r.evaluation(id) != nil {
+		r.redis.HDel(r.ctx, ownersKey, strconv.Itoa(id))
+		return
+	}
+
+	data := expr.Expression
+	request := models.Request{
+		Expression:     data.Expression,
+		Notation:       data.Notation,
+		Priority:       data.Priority,
+		Precision:      precisions.Of(data.Precision),
+		Verify:         data.Verify,
+		ScheduleID:     data.ScheduleID,
+		AllowNonFinite: data.NonFinite,
+	}
+	if data.Format != nil {
+		request.Options = *data.Format
+	}
+
+	// The expression passed the limits when it was submitted; if the limits of
+	// its owner cannot be read now, the defaults still keep the parser bounded.
+	limits, err := r.limitsFor(data.UserID)
+	if err != nil {
+		limits = r.cfg.limits()
+	}
+	node, err := parseInput(limits, request.Expression, request.Notation)
+	if err != nil {
+		expr.Expression.Status = statuses.StatusError
+		expr.Expression.Error = errors.NewBody(err)
+		r.SetExpression(*expr)
+		r.redis.HDel(r.ctx, ownersKey, strconv.Itoa(id))
+		return
+	}
+
+	var timeout time.Duration
+	if data.Deadline != nil {
+		// An expired deadline still needs a timer to stop the evaluation.
+		timeout = max(time.Until(*data.Deadline), time.Nanosecond)
+	}
+
+	// The evaluation starts over, so do its steps and trace.
+	r.redis.Del(r.ctx, stepsKey(id), traceKey(id))
+
+	e := r.newEvaluation(id, models.User{ID: data.UserID}, request, node, resultCacheKey(node, request.Precision), parser.Analyze(node).Tasks, timeout)
+	e.uid = data.UID
+	log.Printf("Expression %d of replica %s is recovered", id, owner)
+
+	r.reserve(e.tasks)
+	go r.run(e)
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/priorities"
+)
+
+// TaskOwner identifies the expression and user a task is queued for, with the
+// priority, precision and number of verifying agents the expression asked
+// for.
+type TaskOwner struct {
+	Expression int
+	User       int
+	Priority   string
+	Precision  string
+	Verify     int
+}
+
+type flowKey struct {
+	user     int
+	priority string
+}
+
+// pendingHeap orders pending tasks by virtual start tag. Every (user,
+// priority) flow advances its own virtual clock by cost/weight per task, so a
+// flow with thousands of queued tasks only gets its weighted share of agents
+// and a newcomer starts right at the current virtual time.
+type pendingHeap []*queuedTask
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	if h[i].tag != h[j].tag {
+		return h[i].tag < h[j].tag
+	}
+	return h[i].data.ID < h[j].data.ID
+}
+
+func (h pendingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pendingHeap) Push(x interface{}) {
+	task := x.(*queuedTask)
+	task.index = len(*h)
+	*h = append(*h, task)
+}
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	task := old[len(old)-1]
+	old[len(old)-1] = nil
+	task.index = -1
+	*h = old[:len(old)-1]
+	return task
+}
+
+// schedule assigns task to the owner's flow and gives it a virtual start tag.
+func (q *TaskQueue) schedule(task *queuedTask, owner TaskOwner) {
+	priority := owner.Priority
+	if !priorities.Valid(priority) {
+		priority = priorities.PriorityNormal
+	}
+
+	key := flowKey{user: owner.User, priority: priority}
+	start := max(q.flows[key], q.vtime)
+
+	task.priority = priority
+	task.tag = start
+	q.flows[key] = start + float64(max(task.data.OperationTime, 1))/float64(priorities.Weight(priority))
+}
+
+// dispatched advances the virtual clock and forgets idle flows.
+func (q *TaskQueue) dispatched(task *queuedTask) {
+	q.vtime = max(q.vtime, task.tag)
+
+	for key, finish := range q.flows {
+		if finish <= q.vtime {
+			delete(q.flows, key)
+		}
+	}
+}
+
+func (q *TaskQueue) push(task *queuedTask) {
+	heap.Push(&q.pending, task)
+}
+
+func (q *TaskQueue) reschedule(task *queuedTask, owner TaskOwner) {
+	q.schedule(task, owner)
+	heap.Fix(&q.pending, task.index)
+}
+
+// take hands out the first pending task the capabilities allow, or returns
+// nil. A verified task stays pending until all its copies are handed out and
+// never goes to the same agent twice.
+func (q *TaskQueue) take(capabilities models.Capabilities, agentID string) *queuedTask {
+	if len(q.pending) == 0 {
+		return nil
+	}
+
+	var first *queuedTask
+	if q.pending[0].eligible(capabilities, agentID) {
+		first = q.pending[0]
+	} else {
+		for _, task := range q.pending {
+			if task.eligible(capabilities, agentID) && (first == nil || q.pending.Less(task.index, first.index)) {
+				first = task
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+
+	first.handed++
+	if first.handed >= first.verify {
+		q.remove(first)
+	}
+	return first
+}
+
+func (t *queuedTask) eligible(capabilities models.Capabilities, agentID string) bool {
+	return capabilities.Supports(t.data) && (agentID == "" || !t.ran(agentID))
+}
+
+func (q *TaskQueue) remove(task *queuedTask) {
+	if task.index >= 0 {
+		heap.Remove(&q.pending, task.index)
+	}
+}
+
+// Position returns the 1-based dispatch position of the first pending task of
+// the expression, or 0 when none of its tasks is waiting.
+func (q *TaskQueue) Position(expression int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var first *queuedTask
+	for _, task := range q.pending {
+		if !task.waitedBy(expression) {
+			continue
+		}
+		if first == nil || q.pending.Less(task.index, first.index) {
+			first = task
+		}
+	}
+	if first == nil {
+		return 0
+	}
+
+	position := 1
+	for _, task := range q.pending {
+		if q.pending.Less(task.index, first.index) {
+			position++
+		}
+	}
+	return position
+}
+
+// Positions returns the position Position reports for every expression with
+// a pending task.
+func (q *TaskQueue) Positions() map[int]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	order := make([]int, len(q.pending))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return q.pending.Less(order[i], order[j])
+	})
+
+	positions := make(map[int]int)
+	for rank, index := range order {
+		for _, waiter := range q.pending[index].waiters {
+			if _, ok := positions[waiter.owner.Expression]; !ok {
+				positions[waiter.owner.Expression] = rank + 1
+			}
+		}
+	}
+	return positions
+}
+
+func (t *queuedTask) waitedBy(expression int) bool {
+	for _, waiter := range t.waiters {
+		if waiter.owner.Expression == expression {
+			return true
+		}
+	}
+	return false
+}
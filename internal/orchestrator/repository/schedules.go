@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/cron"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+)
+
+const (
+	schedulesKey   = "schedules:all"
+	scheduleIDKey  = "schedules:id"
+	schedulesDueAt = "schedules:due"
+)
+
+func scheduleKey(id int) string {
+	return fmt.Sprintf("schedule:%d", id)
+}
+
+func scheduleRunsKey(id int) string {
+	return fmt.Sprintf("schedule:%d:runs", id)
+}
+
+// Schedule stores an expression to be run at request.RunAt or on every fire
+// of request.Cron, which is read in UTC. The expression is checked now, so
+// that a run fails only when the limits or the agents change.
+func (r *CalculatorRepository) Schedule(user models.User, request models.Request) (*models.Schedule, error) {
+	if (request.RunAt == nil) == (request.Cron == "") {
+		return nil, fmt.Errorf("%w: either run_at or cron is required", errors.ErrInvalidRequest)
+	}
+
+	request, _, err := r.check(user, request)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	data := models.ScheduleData{
+		UserID:    user.ID,
+		Status:    statuses.StatusActive,
+		RunAt:     request.RunAt,
+		Cron:      request.Cron,
+		CreatedAt: now,
+	}
+	request.RunAt, request.Cron = nil, ""
+	data.Request = request
+
+	next, err := nextRun(data, now)
+	if err != nil {
+		return nil, err
+	}
+	if next.IsZero() {
+		return nil, fmt.Errorf("%w: the schedule never fires", errors.ErrInvalidRequest)
+	}
+	data.NextRunAt = &next
+
+	id, err := r.redis.Incr(r.ctx, scheduleIDKey)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = int(id)
+
+	if err = r.saveSchedule(data); err != nil {
+		return nil, err
+	}
+	if err = r.redis.SAdd(r.ctx, schedulesKey, strconv.Itoa(data.ID)); err != nil {
+		return nil, err
+	}
+	if err = r.redis.ZAdd(r.ctx, schedulesDueAt, float64(next.UnixMilli()), strconv.Itoa(data.ID)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Schedule %d created, next run at %s", data.ID, next.Format(time.RFC3339))
+	return &models.Schedule{Schedule: data}, nil
+}
+
+// nextRun returns the first fire of the schedule after now, or the zero time
+// when there is none.
+func nextRun(data models.ScheduleData, now time.Time) (time.Time, error) {
+	if data.RunAt != nil {
+		if !data.RunAt.After(now) {
+			if data.RunsTotal == 0 && data.LastRunAt == nil && data.NextRunAt == nil {
+				return time.Time{}, fmt.Errorf("%w: run_at must be in the future", errors.ErrInvalidRequest)
+			}
+			return time.Time{}, nil
+		}
+		return data.RunAt.UTC(), nil
+	}
+
+	spec, err := cron.Parse(data.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", errors.ErrInvalidRequest, err)
+	}
+	return spec.Next(now.UTC()), nil
+}
+
+func (r *CalculatorRepository) saveSchedule(record models.ScheduleData) error {
+	data, err := encodeSchedule(record)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(r.ctx, scheduleKey(record.ID), data, 0)
+}
+
+// encodeSchedule stores a schedule without its runs, which live in their own
+// list.
+func encodeSchedule(record models.ScheduleData) (string, error) {
+	record.Runs = nil
+	data, err := json.Marshal(record)
+	return string(data), err
+}
+
+func (r *CalculatorRepository) loadSchedule(id int) (*models.ScheduleData, error) {
+	data, err := r.redis.Get(r.ctx, scheduleKey(id))
+	if err != nil {
+		return nil, errors.ErrNotFound
+	}
+
+	var record models.ScheduleData
+	if err = json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ownSchedule loads a schedule the user may see: their own, or any for an
+// admin.
+func (r *CalculatorRepository) ownSchedule(user models.User, id int) (*models.ScheduleData, error) {
+	record, err := r.loadSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != roles.RoleAdmin && record.UserID != user.ID {
+		return nil, errors.ErrForbidden
+	}
+	return record, nil
+}
+
+// ListSchedules returns the schedules of the user, or all of them for an
+// admin, without their runs.
+func (r *CalculatorRepository) ListSchedules(user models.User) (*models.Schedules, error) {
+	ids, err := r.redis.SMembers(r.ctx, schedulesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := &models.Schedules{Schedules: make([]models.ScheduleData, 0, len(ids))}
+	for _, idStr := range ids {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		record, err := r.ownSchedule(user, id)
+		if err == errors.ErrNotFound || err == errors.ErrForbidden {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		schedules.Schedules = append(schedules.Schedules, *record)
+	}
+
+	sort.Slice(schedules.Schedules, func(i, j int) bool {
+		return schedules.Schedules[i].ID < schedules.Schedules[j].ID
+	})
+	return schedules, nil
+}
+
+// GetSchedule returns a schedule with the history of its runs, oldest first.
+func (r *CalculatorRepository) GetSchedule(user models.User, id int) (*models.Schedule, error) {
+	record, err := r.ownSchedule(user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := r.scheduleRuns(id)
+	if err != nil {
+		return nil, err
+	}
+	record.Runs = runs
+	return &models.Schedule{Schedule: *record}, nil
+}
+
+// scheduleRuns reads the run history and brings every run up to date with
+// its expression while the expression is still stored.
+func (r *CalculatorRepository) scheduleRuns(id int) ([]models.ScheduleRun, error) {
+	values, err := r.redis.LRange(r.ctx, scheduleRunsKey(id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]models.ScheduleRun, 0, len(values))
+	for _, value := range values {
+		var run models.ScheduleRun
+		if err := json.Unmarshal([]byte(value), &run); err != nil {
+			continue
+		}
+		if run.ExpressionID != 0 {
+			if expr, err := r.GetExpressionByID(run.ExpressionID); err == nil {
+				run.Status = expr.Expression.Status
+				run.Result = expr.Expression.Result
+				run.Error = expr.Expression.Error
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// DeleteSchedule stops a schedule and drops its history; expressions it has
+// already started keep running.
+func (r *CalculatorRepository) DeleteSchedule(user models.User, id int) (*models.Schedule, error) {
+	record, err := r.ownSchedule(user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	member := strconv.Itoa(id)
+	if err = r.redis.ZRem(r.ctx, schedulesDueAt, member); err != nil {
+		return nil, err
+	}
+	if err = r.redis.SRem(r.ctx, schedulesKey, member); err != nil {
+		return nil, err
+	}
+	if err = r.redis.Del(r.ctx, scheduleKey(id), scheduleRunsKey(id)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Schedule %d deleted", id)
+	record.Status = statuses.StatusCancelled
+	record.NextRunAt = nil
+	return &models.Schedule{Schedule: *record}, nil
+}
+
+// runSchedules fires due schedules. Every replica runs it; a fire is claimed
+// by moving the schedule to its next time in one step, so only one replica
+// starts each run.
+func (r *CalculatorRepository) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.fireDue(now.UTC())
+		}
+	}
+}
+
+func (r *CalculatorRepository) fireDue(now time.Time) {
+	ids, err := r.redis.ZRangeByScore(r.ctx, schedulesDueAt, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		log.Println("Failed to read due schedules:", err)
+		return
+	}
+
+	for _, idStr := range ids {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		r.fire(id, now)
+	}
+}
+
+// fire starts one run of a due schedule. Fires missed while no orchestrator
+// was running collapse into this one. The run is submitted with the role the
+// owner has now, and only if the schedule was not deleted before the claim.
+func (r *CalculatorRepository) fire(id int, now time.Time) {
+	member := strconv.Itoa(id)
+	score, err := r.redis.ZScore(r.ctx, schedulesDueAt, member)
+	if err != nil {
+		return
+	}
+
+	record, err := r.loadSchedule(id)
+	if err == errors.ErrNotFound {
+		r.redis.ZSwap(r.ctx, schedulesDueAt, member, score, nil)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to load schedule:", id, err)
+		return
+	}
+
+	user := models.User{ID: record.UserID}
+	user.Role, err = r.userRole(record.UserID)
+	if err != nil && err != errors.ErrNotFound {
+		log.Println("Failed to resolve schedule owner:", id, err)
+		return
+	}
+
+	record.LastRunAt = &now
+	record.RunsTotal++
+	next, err := nextRun(*record, now)
+	if err != nil {
+		log.Println("Invalid schedule:", id, err)
+	}
+
+	var nextScore *float64
+	if next.IsZero() {
+		record.Status = statuses.StatusFinished
+		record.NextRunAt = nil
+	} else {
+		record.NextRunAt = &next
+		value := float64(next.UnixMilli())
+		nextScore = &value
+	}
+
+	data, err := encodeSchedule(*record)
+	if err != nil {
+		log.Println("Failed to save schedule:", id, err)
+		return
+	}
+	claimed, err := r.redis.ZClaim(r.ctx, schedulesDueAt, member, score, nextScore, scheduleKey(id), data)
+	if err != nil || !claimed {
+		return
+	}
+
+	run := models.ScheduleRun{
+		ScheduledAt: time.UnixMilli(int64(score)).UTC(),
+		FiredAt:     now,
+		Status:      statuses.StatusPending,
+	}
+
+	request := record.Request
+	request.ScheduleID = id
+	response, err := r.Submit(user, request)
+	if err != nil {
+		log.Println("Schedule", id, "failed to start a run:", err)
+		run.Status = statuses.StatusError
+		run.Error = errors.NewBody(err)
+	} else {
+		log.Println("Schedule", id, "started expression", response.ID)
+		run.ExpressionID = response.ID
+		run.UID = response.UID
+	}
+
+	r.recordRun(id, run)
+}
+
+// recordRun appends a run to the history of the schedule, which keeps the
+// last SCHEDULE_HISTORY runs.
+func (r *CalculatorRepository) recordRun(id int, run models.ScheduleRun) {
+	data, err := json.Marshal(run)
+	if err != nil {
+		log.Println("Failed to record schedule run:", err)
+		return
+	}
+
+	if err = r.redis.RPush(r.ctx, scheduleRunsKey(id), string(data)); err != nil {
+		log.Println("Failed to record schedule run:", err)
+		return
+	}
+	if r.cfg.ScheduleHistory > 0 {
+		if err = r.redis.LTrim(r.ctx, scheduleRunsKey(id), int64(-r.cfg.ScheduleHistory), -1); err != nil {
+			log.Println("Failed to trim schedule runs:", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+)
+
+func stepsKey(id int) string {
+	return fmt.Sprintf("expression:%d:steps", id)
+}
+
+// recordStep stores the reduction of node to value together with the rest of
+// the expression as it looks once the reduction is applied.
+func (r *CalculatorRepository) recordStep(e *evaluation, node *parser.Node, value float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.values == nil {
+		e.values = make(map[*parser.Node]float64)
+	}
+
+	step := models.Step{Expression: parser.Canonical(parser.Substitute(node, e.values)), Value: value}
+	e.values[node] = value
+	e.steps++
+	step.Index = e.steps
+	step.Remaining = parser.Canonical(parser.Substitute(e.node, e.values))
+
+	data, err := json.Marshal(step)
+	if err != nil {
+		log.Println("Failed to record step:", err)
+		return
+	}
+
+	key := stepsKey(e.id)
+	if err = r.redis.RPush(r.ctx, key, string(data)); err != nil {
+		log.Println("Failed to record step:", err)
+		return
+	}
+	if err = r.redis.Expire(r.ctx, key, 24*time.Hour); err != nil {
+		log.Println("Failed to record step:", err)
+	}
+}
+
+func (r *CalculatorRepository) GetSteps(id int) (*models.Steps, error) {
+	expression, err := r.GetExpressionByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := r.redis.LRange(r.ctx, stepsKey(id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := &models.Steps{ID: id, Status: expression.Expression.Status, Steps: make([]models.Step, 0, len(items))}
+	for _, item := range items {
+		var step models.Step
+		if err = json.Unmarshal([]byte(item), &step); err != nil {
+			return nil, err
+		}
+		steps.Steps = append(steps.Steps, step)
+	}
+
+	return steps, nil
+}
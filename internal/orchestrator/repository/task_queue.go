@@ -0,0 +1,1033 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+	"github.com/xKARASb/Calculator/pkg/utils/priorities"
+	"github.com/xKARASb/Calculator/pkg/utils/retry"
+	"github.com/xKARASb/Calculator/pkg/utils/timings"
+)
+
+type taskKey struct {
+	arg1      float64
+	arg2      float64
+	operation string
+	precision string
+	verify    int
+}
+
+const (
+	SourceAgent     = "agent"
+	SourceCoalesced = "coalesced"
+	SourceMemo      = "memo"
+)
+
+// TaskOutcome is delivered to every expression waiting for a task, together
+// with where the result came from and when it was produced.
+type TaskOutcome struct {
+	Result     float64
+	Err        error
+	TaskID     int
+	Agent      string
+	Source     string
+	EnqueuedAt time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Attempts   []models.TaskAttempt
+}
+
+type taskWaiter struct {
+	done       chan TaskOutcome
+	owner      TaskOwner
+	enqueuedAt time.Time
+	source     string
+}
+
+type queuedTask struct {
+	data      models.TaskData
+	key       taskKey
+	agent     string
+	running   bool
+	startedAt time.Time
+	waiters   []taskWaiter
+	attempts  []models.TaskAttempt
+	base      int
+	err       error
+	priority  string
+	tag       float64
+	index     int
+
+	// A straggling task gets one speculative copy; backup and backupAt
+	// describe it while it runs.
+	copied     bool
+	speculated bool
+	backup     string
+	backupAt   time.Time
+
+	// A verified task runs on verify distinct agents: handed counts the copies
+	// given out, runs holds the agents still working on one and answers what
+	// came back.
+	verify  int
+	handed  int
+	runs    map[string]time.Time
+	answers []verifyAnswer
+}
+
+type verifyAnswer struct {
+	agent     string
+	result    float64
+	err       error
+	startedAt time.Time
+}
+
+// abandonedTTL bounds how long a running task whose expressions were all
+// cancelled is remembered for the agent still working on it.
+const abandonedTTL = time.Hour
+
+type memoEntry struct {
+	result  float64
+	expires time.Time
+}
+
+// TaskQueue hands out operations to agents. Identical pending or running
+// operations are coalesced into one task whose result is fanned out to every
+// waiting expression. Pending tasks are dispatched by weighted fair queueing
+// across users and priorities.
+type TaskQueue struct {
+	mu        sync.Mutex
+	nextID    int
+	pending   pendingHeap
+	flows     map[flowKey]float64
+	vtime     float64
+	tasks     map[int]*queuedTask
+	inflight  map[taskKey]*queuedTask
+	abandoned map[int]time.Time
+	dead      map[int]*queuedTask
+	onDead    func(models.DeadLetter)
+	memo      map[taskKey]memoEntry
+	memoTTL   time.Duration
+	retry     retry.Policies
+	lease     time.Duration
+	factor    float64
+	delay     time.Duration
+	tolerance float64
+	onDissent func(models.Disagreement)
+	lastSweep time.Time
+	last      models.Result
+	stats     models.QueueStats
+}
+
+func NewTaskQueue(memoTTL time.Duration) *TaskQueue {
+	return &TaskQueue{
+		flows:     make(map[flowKey]float64),
+		tasks:     make(map[int]*queuedTask),
+		inflight:  make(map[taskKey]*queuedTask),
+		abandoned: make(map[int]time.Time),
+		dead:      make(map[int]*queuedTask),
+		memo:      make(map[taskKey]memoEntry),
+		memoTTL:   memoTTL,
+	}
+}
+
+// SetRetryPolicy configures how transient task failures are retried. A lease
+// above zero also fails tasks whose agent has not answered within the lease
+// plus the operation time; see Reap.
+func (q *TaskQueue) SetRetryPolicy(policies retry.Policies, lease time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.retry = policies
+	q.lease = lease
+}
+
+// SetSpeculation makes the queue hand an idle agent a copy of a running task
+// once it takes longer than factor times its operation time plus delay. The
+// first result of either copy wins. A factor of zero turns it off.
+func (q *TaskQueue) SetSpeculation(factor float64, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.factor = factor
+	q.delay = delay
+}
+
+// SetVerification sets how far apart the answers to a verified task may be,
+// relative to the larger of them and absolute below 1, and tells handler about
+// every task whose agents disagreed.
+func (q *TaskQueue) SetVerification(tolerance float64, handler func(models.Disagreement)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tolerance = tolerance
+	q.onDissent = handler
+}
+
+// SetDeadLetter makes tasks that exhaust their retries wait for Requeue or
+// Discard instead of failing their expressions; handler is told about every
+// such task.
+func (q *TaskQueue) SetDeadLetter(handler func(models.DeadLetter)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.onDead = handler
+}
+
+// SetIDBase makes task IDs continue after base, so that IDs stay unique across
+// orchestrator runs.
+func (q *TaskQueue) SetIDBase(base int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID = max(q.nextID, base)
+}
+
+func (q *TaskQueue) Enqueue(arg1, arg2 float64, operation string) <-chan TaskOutcome {
+	return q.EnqueueFor(TaskOwner{}, arg1, arg2, operation)
+}
+
+func (q *TaskQueue) EnqueueFor(owner TaskOwner, arg1, arg2 float64, operation string) <-chan TaskOutcome {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	done := make(chan TaskOutcome, 1)
+	precision := precisions.Of(owner.Precision)
+	verify := max(owner.Verify, 1)
+	key := taskKey{arg1: arg1, arg2: arg2, operation: operation, precision: precision, verify: verify}
+
+	if q.memoTTL > 0 {
+		if entry, ok := q.memo[key]; ok && now.Before(entry.expires) {
+			q.stats.MemoHits++
+			done <- TaskOutcome{Result: entry.result, Source: SourceMemo, EnqueuedAt: now, StartedAt: now, FinishedAt: now}
+			return done
+		}
+		q.stats.MemoMisses++
+	}
+
+	if task, ok := q.inflight[key]; ok {
+		q.stats.Coalesced++
+		task.waiters = append(task.waiters, taskWaiter{done: done, owner: owner, enqueuedAt: now, source: SourceCoalesced})
+
+		// A pending task shared with a more urgent expression moves up.
+		if !task.running && priorities.Weight(owner.Priority) > priorities.Weight(task.priority) {
+			q.reschedule(task, owner)
+		}
+		return done
+	}
+
+	q.nextID++
+	task := &queuedTask{
+		data: models.TaskData{
+			ID:            q.nextID,
+			Arg1:          arg1,
+			Arg2:          arg2,
+			Operation:     operation,
+			OperationTime: timings.OperationTime(operation),
+			Precision:     precision,
+		},
+		key:     key,
+		waiters: []taskWaiter{{done: done, owner: owner, enqueuedAt: now, source: SourceAgent}},
+		verify:  verify,
+	}
+	if verify > 1 {
+		task.data.Verify = verify
+		task.runs = make(map[string]time.Time)
+	}
+
+	q.stats.Submitted++
+	q.schedule(task, owner)
+	q.push(task)
+	q.tasks[task.data.ID] = task
+	q.inflight[key] = task
+
+	return done
+}
+
+func (q *TaskQueue) Next(agentID string) (*models.Task, error) {
+	return q.NextFor(agentID, models.Capabilities{})
+}
+
+// NextFor hands the agent the first task in dispatch order that it is able
+// to execute.
+func (q *TaskQueue) NextFor(agentID string, capabilities models.Capabilities) (*models.Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	task := q.take(capabilities, agentID)
+	if task == nil {
+		if task = q.straggler(capabilities, agentID, now); task == nil {
+			return nil, errors.ErrNotAvailable
+		}
+		task.backup = agentID
+		task.backupAt = now
+		return &models.Task{Task: task.data}, nil
+	}
+
+	q.dispatched(task)
+	task.running = true
+	task.agent = agentID
+	task.startedAt = now
+	if task.verify > 1 {
+		task.runs[agentID] = now
+	}
+
+	return &models.Task{Task: task.data}, nil
+}
+
+// Dispatch hands out the next task without an agent yet; its lease starts once
+// Assign names the agent that picked it up.
+func (q *TaskQueue) Dispatch() (*models.Task, error) {
+	return q.DispatchFor(models.Capabilities{})
+}
+
+func (q *TaskQueue) DispatchFor(capabilities models.Capabilities) (*models.Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task := q.take(capabilities, "")
+	if task == nil {
+		if task = q.straggler(capabilities, "", time.Now()); task == nil {
+			return nil, errors.ErrNotAvailable
+		}
+		return &models.Task{Task: task.data}, nil
+	}
+
+	q.dispatched(task)
+	if task.verify > 1 && task.running {
+		return &models.Task{Task: task.data}, nil
+	}
+	task.running = true
+	task.agent = ""
+	task.startedAt = time.Time{}
+
+	return &models.Task{Task: task.data}, nil
+}
+
+func (q *TaskQueue) Assign(id int, agentID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.tasks[id]
+	if !ok || !task.running {
+		return
+	}
+	if task.verify > 1 {
+		q.assignCopy(task, agentID)
+		return
+	}
+	if task.copied && task.backupAt.IsZero() && !task.startedAt.IsZero() {
+		if agentID != task.agent {
+			task.backup = agentID
+			task.backupAt = time.Now()
+		}
+		return
+	}
+	task.agent = agentID
+	task.startedAt = time.Now()
+}
+
+// Undispatch takes back a task handed out by DispatchFor that never reached
+// an agent: a task goes back to the queue and a speculative copy is dropped.
+func (q *TaskQueue) Undispatch(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.tasks[id]
+	if !ok {
+		return
+	}
+	switch {
+	case task.verify > 1:
+		q.handBack(task)
+	case task.running && task.startedAt.IsZero():
+		task.running = false
+		task.handed--
+		q.push(task)
+	case task.copied && task.backupAt.IsZero():
+		task.copied = false
+		q.stats.Speculated--
+	}
+}
+
+// assignCopy records the agent that picked up a copy of a verified task. A
+// copy taken by an agent that already has one goes back to the queue for
+// another agent.
+func (q *TaskQueue) assignCopy(task *queuedTask, agentID string) {
+	if task.ran(agentID) {
+		q.handBack(task)
+		return
+	}
+	if task.startedAt.IsZero() {
+		task.agent = agentID
+		task.startedAt = time.Now()
+	}
+	task.runs[agentID] = time.Now()
+}
+
+// handBack returns a copy of a verified task to the queue.
+func (q *TaskQueue) handBack(task *queuedTask) {
+	task.handed--
+	if task.index < 0 {
+		q.push(task)
+	}
+}
+
+// ran reports whether the agent runs or already answered a copy of the task.
+func (t *queuedTask) ran(agentID string) bool {
+	if _, ok := t.runs[agentID]; ok {
+		return true
+	}
+	for _, answer := range t.answers {
+		if answer.agent == agentID {
+			return true
+		}
+	}
+	return false
+}
+
+// straggler picks the running task that overran its expected time the most
+// and marks it as copied, or returns nil. The agent already running a task
+// never gets its copy.
+func (q *TaskQueue) straggler(capabilities models.Capabilities, agentID string, now time.Time) *queuedTask {
+	if q.factor <= 0 {
+		return nil
+	}
+
+	var (
+		slowest *queuedTask
+		overrun time.Duration
+	)
+	for _, task := range q.tasks {
+		if task.agent == agentID || !capabilities.Supports(task.data) {
+			continue
+		}
+		if over := q.overrun(task, now); over > 0 && (slowest == nil || over > overrun) {
+			slowest, overrun = task, over
+		}
+	}
+
+	if slowest != nil {
+		slowest.copied = true
+		slowest.speculated = true
+		q.stats.Speculated++
+	}
+	return slowest
+}
+
+// overrun is how long a running task without a copy has exceeded its
+// expected time by; zero or less means it has not.
+func (q *TaskQueue) overrun(task *queuedTask, now time.Time) time.Duration {
+	if !task.running || task.copied || task.startedAt.IsZero() || task.verify > 1 {
+		return 0
+	}
+	expected := time.Duration(q.factor*float64(task.data.OperationTime))*time.Millisecond + q.delay
+	return now.Sub(task.startedAt) - expected
+}
+
+// Straggling reports whether some running task is due for a speculative copy.
+func (q *TaskQueue) Straggling(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.factor <= 0 {
+		return false
+	}
+	for _, task := range q.tasks {
+		if q.overrun(task, now) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dropCopy forgets the run of agentID while the other copy of the task keeps
+// running, and reports whether there was one.
+func (q *TaskQueue) dropCopy(task *queuedTask, agentID string) bool {
+	if !task.copied || task.backupAt.IsZero() {
+		return false
+	}
+	if agentID != task.backup {
+		task.agent, task.startedAt = task.backup, task.backupAt
+	}
+	task.copied = false
+	task.backup, task.backupAt = "", time.Time{}
+	return true
+}
+
+func (q *TaskQueue) Peek() (*models.Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil, errors.ErrNotAvailable
+	}
+
+	return &models.Task{Task: q.pending[0].data}, nil
+}
+
+// Complete accepts the result whoever runs the task.
+func (q *TaskQueue) Complete(result models.Result) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, err := q.answerable(result.ID)
+	if err != nil {
+		return err
+	}
+	return q.complete(task, "", result)
+}
+
+// CompleteBy accepts the result from the named agent; for a task with a
+// speculative copy the first answer wins. Agents that were never handed the
+// task are refused, and a transient failure from an earlier attempt is
+// dropped so that it does not fail the run in progress.
+func (q *TaskQueue) CompleteBy(agentID string, result models.Result) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, err := q.answerable(result.ID)
+	if err != nil {
+		return err
+	}
+	if !task.handedTo(agentID) {
+		return fmt.Errorf("%w: task %d was not handed to agent %q", errors.ErrForbidden, result.ID, agentID)
+	}
+	if task.verify <= 1 && result.Error != "" && errors.Transient(errors.FromCode(result.Error)) && !task.runsOn(agentID) {
+		return errors.ErrTaskCancelled
+	}
+	return q.complete(task, agentID, result)
+}
+
+// answerable finds the task a result answers. A late answer for a task that
+// is being retried still counts.
+func (q *TaskQueue) answerable(id int) (*queuedTask, error) {
+	task, ok := q.tasks[id]
+	if !ok || (!task.running && len(task.attempts) == 0) {
+		if _, ok := q.abandoned[id]; ok {
+			delete(q.abandoned, id)
+			return nil, errors.ErrTaskCancelled
+		}
+		return nil, errors.ErrNotFound
+	}
+	return task, nil
+}
+
+// handedTo reports whether the agent runs the task, a copy of it, or ran an
+// earlier attempt.
+func (t *queuedTask) handedTo(agentID string) bool {
+	if t.running && agentID == t.agent {
+		return true
+	}
+	if !t.backupAt.IsZero() && agentID == t.backup {
+		return true
+	}
+	if t.ran(agentID) {
+		return true
+	}
+	for _, attempt := range t.attempts {
+		if attempt.Agent == agentID {
+			return true
+		}
+	}
+	return false
+}
+
+// runsOn reports whether the agent works on the current run of the task or
+// on its speculative copy.
+func (t *queuedTask) runsOn(agentID string) bool {
+	if !t.running {
+		return false
+	}
+	return agentID == t.agent || (!t.backupAt.IsZero() && agentID == t.backup)
+}
+
+func (q *TaskQueue) complete(task *queuedTask, agentID string, result models.Result) error {
+	q.last = result
+
+	if task.verify > 1 {
+		return q.answer(task, agentID, result)
+	}
+
+	if result.Error != "" {
+		err := errors.FromCode(result.Error)
+		if errors.Transient(err) {
+			if !q.dropCopy(task, agentID) {
+				q.fail(task, err)
+			}
+			return nil
+		}
+		q.credit(task, agentID)
+		q.finish(task, TaskOutcome{Err: err})
+		return nil
+	}
+
+	if q.memoTTL > 0 {
+		q.remember(task.key, result.Result)
+	}
+	q.credit(task, agentID)
+	q.finish(task, TaskOutcome{Result: result.Result})
+	return nil
+}
+
+// answer collects the result of one copy of a verified task and settles the
+// task once every copy has answered. A copy that fails transiently is handed
+// out again while the retry policy allows.
+func (q *TaskQueue) answer(task *queuedTask, agentID string, result models.Result) error {
+	startedAt, ok := task.runs[agentID]
+	if !ok {
+		return errors.ErrTaskCancelled
+	}
+
+	var err error
+	if result.Error != "" {
+		err = errors.FromCode(result.Error)
+	}
+	q.closeCopy(task, agentID, err)
+
+	if errors.Transient(err) {
+		q.failCopy(task, err)
+		return nil
+	}
+
+	task.answers = append(task.answers, verifyAnswer{agent: agentID, result: result.Result, err: err, startedAt: startedAt})
+	if len(task.answers) == task.verify {
+		q.settle(task)
+	}
+	return nil
+}
+
+// closeCopy ends the run of agentID in the history of a verified task.
+func (q *TaskQueue) closeCopy(task *queuedTask, agentID string, err error) {
+	task.attempts = append(task.attempts, models.TaskAttempt{
+		Attempt:    len(task.attempts) + 1,
+		Agent:      agentID,
+		StartedAt:  task.runs[agentID],
+		FinishedAt: time.Now(),
+		Error:      errorCode(err),
+	})
+	delete(task.runs, agentID)
+}
+
+// failCopy hands a failed copy of a verified task out again, or fails the
+// task once the copies failed as often as the retry policy allows. It reports
+// whether the task is finished.
+func (q *TaskQueue) failCopy(task *queuedTask, err error) bool {
+	policy := q.retry.For(task.data.Operation)
+	if len(task.attempts)-len(task.answers)-task.base >= policy.Attempts() {
+		task.running = false
+		q.finish(task, TaskOutcome{Err: err})
+		return true
+	}
+
+	q.stats.Retried++
+	q.handBack(task)
+	return false
+}
+
+// settle compares the answers to a verified task. When they all agree the
+// task completes with the first of them; otherwise it fails with every
+// answer, and the agents outside the majority are reported as outliers.
+func (q *TaskQueue) settle(task *queuedTask) {
+	q.stats.Verified++
+	first := task.answers[0]
+	task.running = false
+	task.agent, task.startedAt = first.agent, first.startedAt
+
+	majority := q.majority(task.answers)
+	if len(majority) == len(task.answers) {
+		if first.err == nil && q.memoTTL > 0 {
+			q.remember(task.key, first.result)
+		}
+		q.finish(task, TaskOutcome{Result: first.result, Err: first.err})
+		return
+	}
+
+	q.stats.Disagreements++
+	disagreement := models.Disagreement{Task: task.data, At: time.Now()}
+	for i, answer := range task.answers {
+		disagreement.Results = append(disagreement.Results, errors.Conflict{
+			Agent:  answer.agent,
+			Result: answer.result,
+			Error:  errorCode(answer.err),
+		})
+		if !slices.Contains(majority, i) {
+			disagreement.Outliers = append(disagreement.Outliers, answer.agent)
+		}
+	}
+	for _, waiter := range task.waiters {
+		disagreement.Expressions = append(disagreement.Expressions, waiter.owner.Expression)
+	}
+	if q.onDissent != nil {
+		go q.onDissent(disagreement)
+	}
+
+	q.finish(task, TaskOutcome{Err: &errors.VerificationError{Conflicts: disagreement.Results}})
+}
+
+// majority returns the indexes of the largest group of answers that agree
+// with one of them, or nil when no group has more than half of the answers.
+func (q *TaskQueue) majority(answers []verifyAnswer) []int {
+	var largest []int
+	for _, pivot := range answers {
+		var group []int
+		for i, answer := range answers {
+			if q.agree(pivot, answer) {
+				group = append(group, i)
+			}
+		}
+		if len(group) > len(largest) {
+			largest = group
+		}
+	}
+	if 2*len(largest) <= len(answers) {
+		return nil
+	}
+	return largest
+}
+
+func (q *TaskQueue) agree(a, b verifyAnswer) bool {
+	if a.err != nil || b.err != nil {
+		return errorCode(a.err) == errorCode(b.err)
+	}
+	return math.Abs(a.result-b.result) <= q.tolerance*max(1, math.Abs(a.result), math.Abs(b.result))
+}
+
+// credit attributes the run to the speculative copy when it answered first.
+func (q *TaskQueue) credit(task *queuedTask, agentID string) {
+	if task.copied && agentID != "" && agentID == task.backup {
+		q.stats.SpeculationWins++
+		task.agent, task.startedAt = task.backup, task.backupAt
+	}
+}
+
+// attempt closes the current run of the task in its history.
+func (q *TaskQueue) attempt(task *queuedTask, err error) {
+	if !task.running {
+		return
+	}
+
+	attempt := models.TaskAttempt{
+		Attempt:    len(task.attempts) + 1,
+		Agent:      task.agent,
+		StartedAt:  task.startedAt,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		attempt.Error = errors.Code(err)
+	}
+	task.attempts = append(task.attempts, attempt)
+	task.running = false
+}
+
+// fail retries the task after a backoff while its policy allows, and
+// otherwise fails it for every waiter.
+func (q *TaskQueue) fail(task *queuedTask, err error) {
+	q.attempt(task, err)
+
+	task.copied = false
+	task.backup, task.backupAt = "", time.Time{}
+	task.handed = 0
+
+	policy := q.retry.For(task.data.Operation)
+	if len(task.attempts)-task.base >= policy.Attempts() {
+		if q.onDead != nil {
+			q.bury(task, err)
+			return
+		}
+		q.finish(task, TaskOutcome{Err: err})
+		return
+	}
+
+	q.stats.Retried++
+	task.agent = ""
+	time.AfterFunc(policy.Delay(len(task.attempts)), func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.tasks[task.data.ID] == task && !task.running && task.index < 0 && task.err == nil {
+			q.push(task)
+		}
+	})
+}
+
+func (q *TaskQueue) finish(task *queuedTask, outcome TaskOutcome) {
+	agent, startedAt := task.agent, task.startedAt
+	q.attempt(task, outcome.Err)
+	q.forget(task)
+
+	outcome.TaskID = task.data.ID
+	outcome.Agent = agent
+	outcome.StartedAt = startedAt
+	outcome.FinishedAt = time.Now()
+	outcome.Attempts = task.attempts
+
+	// The copy that lost, or the copies of a verified task still running,
+	// learn that nobody waits for them anymore.
+	if task.speculated || len(task.runs) > 0 {
+		q.abandon(task.data.ID)
+	}
+
+	for _, waiter := range task.waiters {
+		outcome.Source = waiter.source
+		outcome.EnqueuedAt = waiter.enqueuedAt
+		waiter.done <- outcome
+	}
+}
+
+// forget drops the task from the queue; an identical operation enqueued
+// later starts a new task.
+func (q *TaskQueue) forget(task *queuedTask) {
+	delete(q.tasks, task.data.ID)
+	if q.inflight[task.key] == task {
+		delete(q.inflight, task.key)
+	}
+	q.remove(task)
+}
+
+// bury moves a task that exhausted its retries to the dead letters.
+func (q *TaskQueue) bury(task *queuedTask, err error) {
+	q.forget(task)
+	task.err = err
+	q.dead[task.data.ID] = task
+	q.stats.DeadLettered++
+
+	letter := models.DeadLetter{
+		Task:     task.data,
+		Error:    errors.Code(err),
+		Attempts: len(task.attempts),
+		History:  append([]models.TaskAttempt(nil), task.attempts...),
+		DeadAt:   time.Now(),
+	}
+	if len(task.attempts) > 0 {
+		letter.Agent = task.attempts[len(task.attempts)-1].Agent
+	}
+	for _, waiter := range task.waiters {
+		letter.Expressions = append(letter.Expressions, waiter.owner.Expression)
+	}
+
+	go q.onDead(letter)
+}
+
+// Requeue gives a dead task a fresh set of attempts.
+func (q *TaskQueue) Requeue(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.dead[id]
+	if !ok {
+		return errors.ErrNotFound
+	}
+
+	delete(q.dead, id)
+	task.base = len(task.attempts)
+	task.err = nil
+	task.agent = ""
+	task.handed = 0
+
+	q.tasks[id] = task
+	if _, ok := q.inflight[task.key]; !ok {
+		q.inflight[task.key] = task
+	}
+	q.push(task)
+	return nil
+}
+
+// Discard fails a dead task for every expression waiting for it.
+func (q *TaskQueue) Discard(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.dead[id]
+	if !ok {
+		return errors.ErrNotFound
+	}
+
+	delete(q.dead, id)
+	q.finish(task, TaskOutcome{Err: task.err})
+	return nil
+}
+
+// Reap fails running tasks whose agent has been silent for longer than the
+// lease, so that they are retried elsewhere.
+func (q *TaskQueue) Reap(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.lease <= 0 {
+		return
+	}
+
+	for _, task := range q.tasks {
+		limit := q.lease + time.Duration(task.data.OperationTime)*time.Millisecond
+		if task.verify > 1 {
+			q.reapCopies(task, now, limit)
+			continue
+		}
+		if !task.running || task.startedAt.IsZero() || now.Sub(task.startedAt) <= limit {
+			continue
+		}
+		q.stats.Expired++
+		if task.backupAt.IsZero() || now.Sub(task.backupAt) > limit || !q.dropCopy(task, task.agent) {
+			q.fail(task, errors.ErrLeaseExpired)
+		}
+	}
+}
+
+// reapCopies fails the copies of a verified task whose agents went silent.
+func (q *TaskQueue) reapCopies(task *queuedTask, now time.Time, limit time.Duration) {
+	for agentID, startedAt := range task.runs {
+		if now.Sub(startedAt) <= limit {
+			continue
+		}
+		q.stats.Expired++
+		q.closeCopy(task, agentID, errors.ErrLeaseExpired)
+		if q.failCopy(task, errors.ErrLeaseExpired) {
+			return
+		}
+	}
+}
+
+// Withdraw removes a waiter that no longer needs its result. The task itself
+// is dropped only once no coalesced waiter is left; a running task is then
+// reported as cancelled to its agent.
+func (q *TaskQueue) Withdraw(done <-chan TaskOutcome) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id, task := range q.dead {
+		if task.withdraw(done) {
+			if len(task.waiters) == 0 {
+				delete(q.dead, id)
+				q.stats.Cancelled++
+			}
+			return
+		}
+	}
+
+	for id, task := range q.tasks {
+		if !task.withdraw(done) {
+			continue
+		}
+		if len(task.waiters) > 0 {
+			return
+		}
+
+		q.forget(task)
+		q.stats.Cancelled++
+
+		if task.running {
+			q.abandon(id)
+		}
+		return
+	}
+}
+
+func (t *queuedTask) withdraw(done <-chan TaskOutcome) bool {
+	for i, waiter := range t.waiters {
+		if (<-chan TaskOutcome)(waiter.done) == done {
+			t.waiters = append(t.waiters[:i], t.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *TaskQueue) abandon(id int) {
+	now := time.Now()
+	for abandonedID, at := range q.abandoned {
+		if now.Sub(at) > abandonedTTL {
+			delete(q.abandoned, abandonedID)
+		}
+	}
+	q.abandoned[id] = now
+}
+
+// Status reports whether an agent should keep working on a task.
+func (q *TaskQueue) Status(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.tasks[id]; ok {
+		return nil
+	}
+	if _, ok := q.abandoned[id]; ok {
+		return errors.ErrTaskCancelled
+	}
+	return errors.ErrNotFound
+}
+
+// Waiting lists the tasks that no agent has picked up yet, dispatched ones
+// included.
+func (q *TaskQueue) Waiting() []models.PendingTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting := make([]models.PendingTask, 0, len(q.pending))
+	for _, task := range q.tasks {
+		if task.index < 0 && !(task.running && task.startedAt.IsZero()) {
+			continue
+		}
+
+		pending := models.PendingTask{Task: task.data}
+		for _, waiter := range task.waiters {
+			pending.Expressions = append(pending.Expressions, waiter.owner.Expression)
+		}
+		waiting = append(waiting, pending)
+	}
+
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].Task.ID < waiting[j].Task.ID
+	})
+	return waiting
+}
+
+func (q *TaskQueue) Last() (*models.Result, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.last.ID == 0 {
+		return nil, errors.ErrNotAvailable
+	}
+
+	result := q.last
+	return &result, nil
+}
+
+func (q *TaskQueue) Stats() models.QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Pending = len(q.pending)
+	for _, task := range q.tasks {
+		if task.running {
+			stats.Running++
+		}
+	}
+	stats.Dead = len(q.dead)
+	return stats
+}
+
+func (q *TaskQueue) remember(key taskKey, result float64) {
+	now := time.Now()
+
+	if now.Sub(q.lastSweep) > q.memoTTL {
+		for k, entry := range q.memo {
+			if now.After(entry.expires) {
+				delete(q.memo, k)
+			}
+		}
+		q.lastSweep = now
+	}
+
+	q.memo[key] = memoEntry{result: result, expires: now.Add(q.memoTTL)}
+}
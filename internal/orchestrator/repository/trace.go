@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+)
+
+func traceKey(id int) string {
+	return fmt.Sprintf("expression:%d:trace", id)
+}
+
+func (r *CalculatorRepository) recordTrace(e *evaluation, node *parser.Node, arg1, arg2 float64, outcome TaskOutcome) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.traced == nil {
+		e.traced = make(map[*parser.Node]int)
+	}
+
+	entry := models.TraceEntry{
+		Index:      len(e.traced) + 1,
+		TaskID:     outcome.TaskID,
+		Operation:  node.Token,
+		Arg1:       arg1,
+		Arg2:       arg2,
+		Result:     outcome.Result,
+		Agent:      outcome.Agent,
+		Source:     outcome.Source,
+		EnqueuedAt: outcome.EnqueuedAt,
+		StartedAt:  outcome.StartedAt,
+		FinishedAt: outcome.FinishedAt,
+		RunMS:      outcome.FinishedAt.Sub(outcome.StartedAt).Milliseconds(),
+		DependsOn:  e.dependencies(node),
+		Attempts:   outcome.Attempts,
+	}
+	if outcome.Err != nil {
+		entry.Error = errors.Code(outcome.Err)
+	}
+	if wait := outcome.StartedAt.Sub(outcome.EnqueuedAt); wait > 0 {
+		entry.QueueWaitMS = wait.Milliseconds()
+	}
+	e.traced[node] = entry.Index
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Failed to record trace:", err)
+		return
+	}
+
+	key := traceKey(e.id)
+	if err = r.redis.RPush(r.ctx, key, string(data)); err != nil {
+		log.Println("Failed to record trace:", err)
+		return
+	}
+	if err = r.redis.Expire(r.ctx, key, 24*time.Hour); err != nil {
+		log.Println("Failed to record trace:", err)
+	}
+}
+
+// dependencies returns the trace entries of the tasks whose results node
+// consumed; unary minus is evaluated locally and is looked through.
+func (e *evaluation) dependencies(node *parser.Node) []int {
+	var deps []int
+	for _, child := range []*parser.Node{node.Left, node.Right} {
+		for child.Type == parser.TypeUnary {
+			child = child.Operand
+		}
+		if index, ok := e.traced[child]; ok {
+			deps = append(deps, index)
+		}
+	}
+	return deps
+}
+
+func (r *CalculatorRepository) GetTrace(id int) (*models.Trace, error) {
+	expression, err := r.GetExpressionByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := r.redis.LRange(r.ctx, traceKey(id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &models.Trace{ID: id, Status: expression.Expression.Status, Tasks: make([]models.TraceEntry, 0, len(items))}
+	for _, item := range items {
+		var entry models.TraceEntry
+		if err = json.Unmarshal([]byte(item), &entry); err != nil {
+			return nil, err
+		}
+		trace.Tasks = append(trace.Tasks, entry)
+	}
+	trace.Summary = summarize(trace.Tasks)
+
+	return trace, nil
+}
+
+// summarize walks back from the last finished task, always following the
+// dependency that finished last: that chain is what the expression waited on.
+func summarize(entries []models.TraceEntry) models.TraceSummary {
+	summary := models.TraceSummary{
+		Tasks:        len(entries),
+		CriticalPath: []int{},
+		Agents:       make(map[string]int),
+		Sources:      make(map[string]int),
+	}
+	if len(entries) == 0 {
+		return summary
+	}
+
+	byIndex := make(map[int]models.TraceEntry, len(entries))
+	first, last := entries[0], entries[0]
+	for _, entry := range entries {
+		byIndex[entry.Index] = entry
+		summary.QueueWaitMS += entry.QueueWaitMS
+		summary.MaxQueueWaitMS = max(summary.MaxQueueWaitMS, entry.QueueWaitMS)
+		summary.Sources[entry.Source]++
+		if entry.Agent != "" && entry.Source == SourceAgent {
+			summary.Agents[entry.Agent]++
+		}
+		if entry.EnqueuedAt.Before(first.EnqueuedAt) {
+			first = entry
+		}
+		if entry.FinishedAt.After(last.FinishedAt) {
+			last = entry
+		}
+	}
+	summary.AvgQueueWaitMS = summary.QueueWaitMS / int64(len(entries))
+	summary.WallMS = last.FinishedAt.Sub(first.EnqueuedAt).Milliseconds()
+
+	path := []models.TraceEntry{last}
+	for current := last; len(current.DependsOn) > 0; {
+		next := byIndex[current.DependsOn[0]]
+		for _, index := range current.DependsOn[1:] {
+			if dep := byIndex[index]; dep.FinishedAt.After(next.FinishedAt) {
+				next = dep
+			}
+		}
+		path = append(path, next)
+		current = next
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		summary.CriticalPath = append(summary.CriticalPath, path[i].Index)
+		summary.CriticalPathWaitMS += path[i].QueueWaitMS
+	}
+	summary.CriticalPathMS = last.FinishedAt.Sub(path[len(path)-1].EnqueuedAt).Milliseconds()
+
+	return summary
+}
@@ -6,13 +6,41 @@ import (
 
 type CalculatorRepository interface {
 	Calculate(expression string) (int, error)
+	Submit(user models.User, request models.Request) (*models.Response, error)
+	Schedule(user models.User, request models.Request) (*models.Schedule, error)
+	ListSchedules(user models.User) (*models.Schedules, error)
+	GetSchedule(user models.User, id int) (*models.Schedule, error)
+	DeleteSchedule(user models.User, id int) (*models.Schedule, error)
+	Validate(user models.User, request models.Request) (*models.Validation, error)
+	Convert(user models.User, request models.Request) (*models.Conversion, error)
 	GetAllExpressions() ([]models.Expression, error)
 	GetExpressionByID(id int) (*models.Expression, error)
+	ResolveExpressionID(uid string) (int, error)
+	GetSteps(id int) (*models.Steps, error)
+	GetTrace(id int) (*models.Trace, error)
+	Cancel(user models.User, id int) (*models.Expression, error)
+	TaskStatus(taskID int) error
 	GetCurrentTask() (*models.Task, error)
+	NextTask(agentID string) (*models.Task, error)
+	SetTaskResult(agentID string, result models.Result) error
+	NextTasks(agentID string, limit int) (*models.Tasks, error)
+	SetTaskResults(agentID string, results []models.Result) (*models.ResultStatuses, error)
 	GetResult() (*models.Result, error)
+	Metrics() (*models.Metrics, error)
 	SetExpression(expression models.Expression) error
 	Register(login, password string) error
-	Login(login, password string) error
+	Login(login, password string) (*models.User, error)
+	SetUserRole(login, role string) error
+	GetUserLimits(userID int) (*models.UserLimits, error)
+	SetUserLimits(limits models.UserLimits) error
+	DeleteUserLimits(userID int) error
+	ListDeadLetters() (*models.DeadLetters, error)
+	GetDeadLetter(id int) (*models.DeadLetter, error)
+	RequeueDeadLetter(id int) (*models.DeadLetter, error)
+	DiscardDeadLetter(id int) (*models.DeadLetter, error)
+	ListAgents() (*models.Agents, error)
+	ReleaseAgent(agentID string) (*models.AgentInfo, error)
+	RegisterAgent(agentID string, capabilities models.Capabilities) error
 }
 
 type CalculatorService struct {
@@ -27,6 +55,34 @@ func (s CalculatorService) Calculate(expression string) (int, error) {
 	return s.repository.Calculate(expression)
 }
 
+func (s CalculatorService) Submit(user models.User, request models.Request) (*models.Response, error) {
+	return s.repository.Submit(user, request)
+}
+
+func (s CalculatorService) Schedule(user models.User, request models.Request) (*models.Schedule, error) {
+	return s.repository.Schedule(user, request)
+}
+
+func (s CalculatorService) ListSchedules(user models.User) (*models.Schedules, error) {
+	return s.repository.ListSchedules(user)
+}
+
+func (s CalculatorService) GetSchedule(user models.User, id int) (*models.Schedule, error) {
+	return s.repository.GetSchedule(user, id)
+}
+
+func (s CalculatorService) DeleteSchedule(user models.User, id int) (*models.Schedule, error) {
+	return s.repository.DeleteSchedule(user, id)
+}
+
+func (s CalculatorService) Validate(user models.User, request models.Request) (*models.Validation, error) {
+	return s.repository.Validate(user, request)
+}
+
+func (s CalculatorService) Convert(user models.User, request models.Request) (*models.Conversion, error) {
+	return s.repository.Convert(user, request)
+}
+
 func (s CalculatorService) GetAllExpressions() ([]models.Expression, error) {
 	return s.repository.GetAllExpressions()
 }
@@ -35,14 +91,54 @@ func (s CalculatorService) GetExpressionByID(id int) (*models.Expression, error)
 	return s.repository.GetExpressionByID(id)
 }
 
+func (s CalculatorService) ResolveExpressionID(uid string) (int, error) {
+	return s.repository.ResolveExpressionID(uid)
+}
+
+func (s CalculatorService) GetSteps(id int) (*models.Steps, error) {
+	return s.repository.GetSteps(id)
+}
+
+func (s CalculatorService) GetTrace(id int) (*models.Trace, error) {
+	return s.repository.GetTrace(id)
+}
+
+func (s CalculatorService) Cancel(user models.User, id int) (*models.Expression, error) {
+	return s.repository.Cancel(user, id)
+}
+
+func (s CalculatorService) TaskStatus(taskID int) error {
+	return s.repository.TaskStatus(taskID)
+}
+
 func (s CalculatorService) GetCurrentTask() (*models.Task, error) {
 	return s.repository.GetCurrentTask()
 }
 
+func (s CalculatorService) NextTask(agentID string) (*models.Task, error) {
+	return s.repository.NextTask(agentID)
+}
+
+func (s CalculatorService) SetTaskResult(agentID string, result models.Result) error {
+	return s.repository.SetTaskResult(agentID, result)
+}
+
+func (s CalculatorService) NextTasks(agentID string, limit int) (*models.Tasks, error) {
+	return s.repository.NextTasks(agentID, limit)
+}
+
+func (s CalculatorService) SetTaskResults(agentID string, results []models.Result) (*models.ResultStatuses, error) {
+	return s.repository.SetTaskResults(agentID, results)
+}
+
 func (s CalculatorService) GetResult() (*models.Result, error) {
 	return s.repository.GetResult()
 }
 
+func (s CalculatorService) Metrics() (*models.Metrics, error) {
+	return s.repository.Metrics()
+}
+
 func (s CalculatorService) SetExpression(expression models.Expression) error {
 	return s.repository.SetExpression(expression)
 }
@@ -50,6 +146,50 @@ func (s CalculatorService) Register(login, password string) error {
 	return s.repository.Register(login, password)
 }
 
-func (s CalculatorService) Login(login, password string) error {
+func (s CalculatorService) Login(login, password string) (*models.User, error) {
 	return s.repository.Login(login, password)
 }
+
+func (s CalculatorService) SetUserRole(login, role string) error {
+	return s.repository.SetUserRole(login, role)
+}
+
+func (s CalculatorService) GetUserLimits(userID int) (*models.UserLimits, error) {
+	return s.repository.GetUserLimits(userID)
+}
+
+func (s CalculatorService) SetUserLimits(limits models.UserLimits) error {
+	return s.repository.SetUserLimits(limits)
+}
+
+func (s CalculatorService) DeleteUserLimits(userID int) error {
+	return s.repository.DeleteUserLimits(userID)
+}
+
+func (s CalculatorService) ListDeadLetters() (*models.DeadLetters, error) {
+	return s.repository.ListDeadLetters()
+}
+
+func (s CalculatorService) GetDeadLetter(id int) (*models.DeadLetter, error) {
+	return s.repository.GetDeadLetter(id)
+}
+
+func (s CalculatorService) RequeueDeadLetter(id int) (*models.DeadLetter, error) {
+	return s.repository.RequeueDeadLetter(id)
+}
+
+func (s CalculatorService) DiscardDeadLetter(id int) (*models.DeadLetter, error) {
+	return s.repository.DiscardDeadLetter(id)
+}
+
+func (s CalculatorService) ListAgents() (*models.Agents, error) {
+	return s.repository.ListAgents()
+}
+
+func (s CalculatorService) ReleaseAgent(agentID string) (*models.AgentInfo, error) {
+	return s.repository.ReleaseAgent(agentID)
+}
+
+func (s CalculatorService) RegisterAgent(agentID string, capabilities models.Capabilities) error {
+	return s.repository.RegisterAgent(agentID, capabilities)
+}
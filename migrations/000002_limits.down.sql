@@ -0,0 +1,3 @@
+drop table if exists user_limits;
+
+alter table users drop column if exists role;
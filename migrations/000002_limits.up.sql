@@ -0,0 +1,9 @@
+alter table users add column if not exists role text not null default 'user';
+
+create table if not exists user_limits(
+    user_id integer primary key references users(id) on delete cascade,
+    max_length integer,
+    max_depth integer,
+    max_tasks integer,
+    max_estimated_ms integer
+);
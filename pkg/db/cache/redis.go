@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -32,6 +33,10 @@ func (c *RedisClient) Set(ctx context.Context, key string, value string, expirat
 	return c.Client.Set(ctx, key, value, expiration).Err()
 }
 
+func (c *RedisClient) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	return c.Client.SetNX(ctx, key, value, expiration).Result()
+}
+
 func (c *RedisClient) Get(ctx context.Context, key string) (string, error) {
 	return c.Client.Get(ctx, key).Result()
 }
@@ -40,6 +45,183 @@ func (c *RedisClient) SAdd(ctx context.Context, key string, members ...string) e
 	return c.Client.SAdd(ctx, key, members).Err()
 }
 
+func (c *RedisClient) SRem(ctx context.Context, key string, members ...string) error {
+	return c.Client.SRem(ctx, key, members).Err()
+}
+
+func (c *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return c.Client.Incr(ctx, key).Result()
+}
+
+func (c *RedisClient) Del(ctx context.Context, keys ...string) error {
+	return c.Client.Del(ctx, keys...).Err()
+}
+
 func (c *RedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
 	return c.Client.SMembers(ctx, key).Result()
 }
+
+func (c *RedisClient) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return c.Client.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+func (c *RedisClient) ZCount(ctx context.Context, key string, min, max string) (int64, error) {
+	return c.Client.ZCount(ctx, key, min, max).Result()
+}
+
+func (c *RedisClient) ZRangeByScore(ctx context.Context, key string, min, max string) ([]string, error) {
+	return c.Client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: min, Max: max}).Result()
+}
+
+func (c *RedisClient) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	return c.Client.ZScore(ctx, key, member).Result()
+}
+
+func (c *RedisClient) ZRem(ctx context.Context, key string, members ...string) error {
+	return c.Client.ZRem(ctx, key, members).Err()
+}
+
+func (c *RedisClient) ZRemRangeByScore(ctx context.Context, key string, min, max string) error {
+	return c.Client.ZRemRangeByScore(ctx, key, min, max).Err()
+}
+
+func (c *RedisClient) RPush(ctx context.Context, key string, values ...string) error {
+	return c.Client.RPush(ctx, key, values).Err()
+}
+
+func (c *RedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.Client.LRange(ctx, key, start, stop).Result()
+}
+
+func (c *RedisClient) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return c.Client.LTrim(ctx, key, start, stop).Err()
+}
+
+func (c *RedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return c.Client.Expire(ctx, key, expiration).Err()
+}
+
+func (c *RedisClient) LPop(ctx context.Context, key string) (string, error) {
+	return c.Client.LPop(ctx, key).Result()
+}
+
+// BLPop waits up to timeout for a value in key; it returns redis.Nil when
+// none arrived.
+func (c *RedisClient) BLPop(ctx context.Context, timeout time.Duration, key string) (string, error) {
+	values, err := c.Client.BLPop(ctx, timeout, key).Result()
+	if err != nil {
+		return "", err
+	}
+	return values[1], nil
+}
+
+func (c *RedisClient) LLen(ctx context.Context, key string) (int64, error) {
+	return c.Client.LLen(ctx, key).Result()
+}
+
+func (c *RedisClient) LIndex(ctx context.Context, key string, index int64) (string, error) {
+	return c.Client.LIndex(ctx, key, index).Result()
+}
+
+func (c *RedisClient) HSet(ctx context.Context, key string, field string, value string) error {
+	return c.Client.HSet(ctx, key, field, value).Err()
+}
+
+func (c *RedisClient) HGet(ctx context.Context, key string, field string) (string, error) {
+	return c.Client.HGet(ctx, key, field).Result()
+}
+
+func (c *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return c.Client.HGetAll(ctx, key).Result()
+}
+
+func (c *RedisClient) HIncrBy(ctx context.Context, key string, field string, incr int64) (int64, error) {
+	return c.Client.HIncrBy(ctx, key, field, incr).Result()
+}
+
+func (c *RedisClient) HDel(ctx context.Context, key string, fields ...string) error {
+	return c.Client.HDel(ctx, key, fields...).Err()
+}
+
+func (c *RedisClient) Exists(ctx context.Context, key string) (bool, error) {
+	count, err := c.Client.Exists(ctx, key).Result()
+	return count > 0, err
+}
+
+var lockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// Lock takes or extends a lock held by owner for ttl. It reports whether
+// owner holds the lock afterwards.
+func (c *RedisClient) Lock(ctx context.Context, key string, owner string, ttl time.Duration) (bool, error) {
+	held, err := lockScript.Run(ctx, c.Client, []string{key}, owner, ttl.Milliseconds()).Int()
+	return held == 1, err
+}
+
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (c *RedisClient) Unlock(ctx context.Context, key string, owner string) error {
+	return unlockScript.Run(ctx, c.Client, []string{key}, owner).Err()
+}
+
+var zswapScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+if ARGV[3] == "" then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+else
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+end
+return 1`)
+
+// ZSwap moves member from score old to score next, or removes it when next
+// is nil, but only while its score still is old. It reports whether it did.
+func (c *RedisClient) ZSwap(ctx context.Context, key string, member string, old float64, next *float64) (bool, error) {
+	score := ""
+	if next != nil {
+		score = strconv.FormatFloat(*next, 'f', -1, 64)
+	}
+	swapped, err := zswapScript.Run(ctx, c.Client, []string{key}, member, strconv.FormatFloat(old, 'f', -1, 64), score).Int()
+	return swapped == 1, err
+}
+
+var zclaimScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+	return 0
+end
+if ARGV[3] == "" then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+else
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+end
+redis.call("SET", KEYS[2], ARGV[4])
+return 1`)
+
+// ZClaim is ZSwap that in the same step overwrites record with value, but
+// only while record still exists; a member whose record is gone is removed
+// instead. It reports whether it swapped.
+func (c *RedisClient) ZClaim(ctx context.Context, key string, member string, old float64, next *float64, record string, value string) (bool, error) {
+	score := ""
+	if next != nil {
+		score = strconv.FormatFloat(*next, 'f', -1, 64)
+	}
+	claimed, err := zclaimScript.Run(ctx, c.Client, []string{key, record}, member, strconv.FormatFloat(old, 'f', -1, 64), score, value).Int()
+	return claimed == 1, err
+}
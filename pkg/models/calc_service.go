@@ -1,19 +1,64 @@
 package models
 
-import "github.com/volatiletech/null/v9"
+import (
+	"slices"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/format"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+
+	"github.com/volatiletech/null/v9"
+)
 
 type Request struct {
-	Expression string `json:"expression"`
+	Expression     string     `json:"expression"`
+	Notation       string     `json:"notation,omitempty"`
+	AllowNonFinite bool       `json:"allow_non_finite"`
+	TimeoutMS      int        `json:"timeout_ms,omitempty"`
+	Priority       string     `json:"priority,omitempty"`
+	Precision      string     `json:"precision,omitempty"`
+	Verify         int        `json:"verify,omitempty"`
+	RunAt          *time.Time `json:"run_at,omitempty"`
+	Cron           string     `json:"cron,omitempty"`
+	ScheduleID     int        `json:"-"`
+	format.Options
 }
 
 type Response struct {
-	ID int `json:"id"`
+	ID         int             `json:"id"`
+	UID        string          `json:"uid,omitempty"`
+	Cached     bool            `json:"cached,omitempty"`
+	Expression *ExpressionData `json:"expression,omitempty"`
 }
 
 type ExpressionData struct {
-	ID     int     `json:"id"`
-	Status string  `json:"status"`
-	Result float64 `json:"result"`
+	ID            int             `json:"id"`
+	UID           string          `json:"uid,omitempty"`
+	Status        string          `json:"status"`
+	UserID        int             `json:"user_id,omitempty"`
+	Expression    string          `json:"expression,omitempty"`
+	Notation      string          `json:"notation,omitempty"`
+	Priority      string          `json:"priority,omitempty"`
+	Precision     string          `json:"precision,omitempty"`
+	Verify        int             `json:"verify,omitempty"`
+	ScheduleID    int             `json:"schedule_id,omitempty"`
+	NonFinite     bool            `json:"allow_non_finite,omitempty"`
+	Result        float64         `json:"result"`
+	Formatted     string          `json:"formatted,omitempty"`
+	Rendered      string          `json:"rendered,omitempty"`
+	TasksTotal    int             `json:"tasks_total"`
+	TasksDone     int             `json:"tasks_done"`
+	Progress      float64         `json:"progress"`
+	EtaMS         int             `json:"eta_ms"`
+	ElapsedMS     int64           `json:"elapsed_ms,omitempty"`
+	QueuePosition int             `json:"queue_position,omitempty"`
+	Unschedulable bool            `json:"unschedulable,omitempty"`
+	Deadline      *time.Time      `json:"deadline,omitempty"`
+	Format        *format.Options `json:"format_options,omitempty"`
+	Cached        bool            `json:"cached,omitempty"`
+	Error         *errors.Body    `json:"error,omitempty"`
 }
 
 type Expression struct {
@@ -26,15 +71,238 @@ type TaskData struct {
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int     `json:"operation_time"`
+	Precision     string  `json:"precision,omitempty"`
+	Verify        int     `json:"verify,omitempty"`
 }
 
 type Task struct {
 	Task TaskData `json:"task"`
 }
 
+type Tasks struct {
+	Tasks []TaskData `json:"tasks"`
+}
+
 type Result struct {
 	ID     int     `json:"id"`
 	Result float64 `json:"result"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type Results struct {
+	Results []Result `json:"results"`
+}
+
+// ResultStatus tells an agent what became of one result of a batch.
+type ResultStatus struct {
+	ID     int          `json:"id"`
+	Status string       `json:"status"`
+	Error  *errors.Body `json:"error,omitempty"`
+}
+
+type ResultStatuses struct {
+	Results []ResultStatus `json:"results"`
+}
+
+type QueueStats struct {
+	Pending         int   `json:"pending"`
+	Running         int   `json:"running"`
+	Submitted       int64 `json:"submitted"`
+	Coalesced       int64 `json:"coalesced"`
+	Cancelled       int64 `json:"cancelled"`
+	Retried         int64 `json:"retried"`
+	Expired         int64 `json:"expired"`
+	Speculated      int64 `json:"speculated"`
+	SpeculationWins int64 `json:"speculation_wins"`
+	Verified        int64 `json:"verified"`
+	Disagreements   int64 `json:"disagreements"`
+	Dead            int   `json:"dead"`
+	DeadLettered    int64 `json:"dead_lettered"`
+	MemoHits        int64 `json:"memo_hits"`
+	MemoMisses      int64 `json:"memo_misses"`
+}
+
+// PendingTask is a task that waits for an agent, with the expressions that
+// need its result.
+type PendingTask struct {
+	Task        TaskData `json:"task"`
+	Expressions []int    `json:"expressions"`
+}
+
+type Metrics struct {
+	Queue         QueueStats              `json:"queue"`
+	Held          int                     `json:"held"`
+	Agents        map[string]Capabilities `json:"agents"`
+	Unschedulable []PendingTask           `json:"unschedulable"`
+}
+
+// Capabilities are the operations and precision modes an agent declares at
+// registration, with the number of tasks it runs at once. An empty list
+// accepts anything.
+type Capabilities struct {
+	Operations  []string `json:"operations,omitempty"`
+	Precisions  []string `json:"precisions,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty"`
+}
+
+func (c Capabilities) Supports(task TaskData) bool {
+	return (len(c.Operations) == 0 || slices.Contains(c.Operations, task.Operation)) &&
+		(len(c.Precisions) == 0 || slices.Contains(c.Precisions, precisions.Of(task.Precision)))
+}
+
+// Disagreement is a task whose agents did not agree on the result. Outliers
+// are the agents outside the majority, or all of them when there is none.
+type Disagreement struct {
+	Task        TaskData          `json:"task"`
+	Results     []errors.Conflict `json:"results"`
+	Outliers    []string          `json:"outliers"`
+	Expressions []int             `json:"expressions"`
+	At          time.Time         `json:"at"`
+}
+
+// AgentInfo is a registered agent with the number of times it disagreed with
+// the other agents running the same task.
+type AgentInfo struct {
+	ID            string        `json:"id"`
+	Capabilities  *Capabilities `json:"capabilities,omitempty"`
+	Disagreements int           `json:"disagreements"`
+	Quarantined   bool          `json:"quarantined"`
+}
+
+type Agents struct {
+	Agents []AgentInfo `json:"agents"`
+}
+
+// ScheduleData runs an expression once at RunAt, or every time the Cron spec
+// fires; every run is a new expression.
+type ScheduleData struct {
+	ID        int           `json:"id"`
+	UserID    int           `json:"user_id,omitempty"`
+	Status    string        `json:"status"`
+	Request   Request       `json:"request"`
+	RunAt     *time.Time    `json:"run_at,omitempty"`
+	Cron      string        `json:"cron,omitempty"`
+	NextRunAt *time.Time    `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time    `json:"last_run_at,omitempty"`
+	RunsTotal int           `json:"runs_total"`
+	CreatedAt time.Time     `json:"created_at"`
+	Runs      []ScheduleRun `json:"runs,omitempty"`
+}
+
+type Schedule struct {
+	Schedule ScheduleData `json:"schedule"`
+}
+
+type Schedules struct {
+	Schedules []ScheduleData `json:"schedules"`
+}
+
+// ScheduleRun is one fire of a schedule with the state of the expression it
+// started; a run that could not start keeps the error instead.
+type ScheduleRun struct {
+	ExpressionID int          `json:"expression_id,omitempty"`
+	UID          string       `json:"uid,omitempty"`
+	ScheduledAt  time.Time    `json:"scheduled_at"`
+	FiredAt      time.Time    `json:"fired_at"`
+	Status       string       `json:"status"`
+	Result       float64      `json:"result"`
+	Error        *errors.Body `json:"error,omitempty"`
+}
+
+type Validation struct {
+	Valid          bool           `json:"valid"`
+	Error          *errors.Body   `json:"error,omitempty"`
+	AST            *parser.Node   `json:"ast,omitempty"`
+	Operations     map[string]int `json:"operations,omitempty"`
+	TasksTotal     int            `json:"tasks_total"`
+	CriticalPath   int            `json:"critical_path"`
+	CriticalPathMS int            `json:"critical_path_ms"`
+	LiveAgents     int            `json:"live_agents"`
+	EstimatedMS    int            `json:"estimated_ms"`
+}
+
+type Step struct {
+	Index      int     `json:"index"`
+	Expression string  `json:"expression"`
+	Value      float64 `json:"value"`
+	Remaining  string  `json:"remaining"`
+	Text       string  `json:"text,omitempty"`
+}
+
+type Steps struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Steps  []Step `json:"steps"`
+}
+
+type TaskAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Agent      string    `json:"agent,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DeadLetter is a task that exhausted its retries. The expressions waiting for
+// it stay in progress until the task is requeued or discarded.
+type DeadLetter struct {
+	ID          int           `json:"id"`
+	Task        TaskData      `json:"task"`
+	Error       string        `json:"error"`
+	Agent       string        `json:"agent,omitempty"`
+	Attempts    int           `json:"attempts"`
+	History     []TaskAttempt `json:"history"`
+	Expressions []int         `json:"expressions"`
+	DeadAt      time.Time     `json:"dead_at"`
+}
+
+type DeadLetters struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+}
+
+type TraceEntry struct {
+	Index       int           `json:"index"`
+	TaskID      int           `json:"task_id,omitempty"`
+	Operation   string        `json:"operation"`
+	Arg1        float64       `json:"arg1"`
+	Arg2        float64       `json:"arg2"`
+	Result      float64       `json:"result"`
+	Error       string        `json:"error,omitempty"`
+	Agent       string        `json:"agent,omitempty"`
+	Source      string        `json:"source"`
+	EnqueuedAt  time.Time     `json:"enqueued_at"`
+	StartedAt   time.Time     `json:"started_at"`
+	FinishedAt  time.Time     `json:"finished_at"`
+	QueueWaitMS int64         `json:"queue_wait_ms"`
+	RunMS       int64         `json:"run_ms"`
+	DependsOn   []int         `json:"depends_on,omitempty"`
+	Attempts    []TaskAttempt `json:"attempts,omitempty"`
+}
+
+type TraceSummary struct {
+	Tasks              int            `json:"tasks"`
+	WallMS             int64          `json:"wall_ms"`
+	QueueWaitMS        int64          `json:"queue_wait_ms"`
+	MaxQueueWaitMS     int64          `json:"max_queue_wait_ms"`
+	AvgQueueWaitMS     int64          `json:"avg_queue_wait_ms"`
+	CriticalPath       []int          `json:"critical_path"`
+	CriticalPathMS     int64          `json:"critical_path_ms"`
+	CriticalPathWaitMS int64          `json:"critical_path_wait_ms"`
+	Agents             map[string]int `json:"agents"`
+	Sources            map[string]int `json:"sources"`
+}
+
+type Trace struct {
+	ID      int          `json:"id"`
+	Status  string       `json:"status"`
+	Tasks   []TraceEntry `json:"tasks"`
+	Summary TraceSummary `json:"summary"`
+}
+
+type Conversion struct {
+	Infix  string `json:"infix"`
+	RPN    string `json:"rpn"`
+	Prefix string `json:"prefix"`
 }
 
 type Auth struct {
@@ -47,4 +315,20 @@ type User struct {
 	Login    string      `json:"login" db:"login"`
 	Password string      `json:"password" db:"password"`
 	Refresh  null.String `json:"refresh_token" db:"refresh_token"`
+	Role     string      `json:"role" db:"role"`
+}
+
+type Limits struct {
+	MaxLength      int `json:"max_length"`
+	MaxDepth       int `json:"max_depth"`
+	MaxTasks       int `json:"max_tasks"`
+	MaxEstimatedMS int `json:"max_estimated_ms"`
+}
+
+type UserLimits struct {
+	UserID         int      `json:"user_id" db:"user_id"`
+	MaxLength      null.Int `json:"max_length" db:"max_length"`
+	MaxDepth       null.Int `json:"max_depth" db:"max_depth"`
+	MaxTasks       null.Int `json:"max_tasks" db:"max_tasks"`
+	MaxEstimatedMS null.Int `json:"max_estimated_ms" db:"max_estimated_ms"`
 }
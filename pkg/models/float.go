@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// encoding/json refuses to marshal infinities and NaN, so non-finite values
+// travel as the strings "+Inf", "-Inf" and "NaN".
+
+func encodeFloat(value float64) interface{} {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return value
+}
+
+func decodeFloat(data json.RawMessage) (float64, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
+	}
+
+	if data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return 0, err
+		}
+		return strconv.ParseFloat(text, 64)
+	}
+
+	var value float64
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+func (e ExpressionData) MarshalJSON() ([]byte, error) {
+	type alias ExpressionData
+	return json.Marshal(struct {
+		alias
+		Result interface{} `json:"result"`
+	}{alias(e), encodeFloat(e.Result)})
+}
+
+func (e *ExpressionData) UnmarshalJSON(data []byte) error {
+	type alias ExpressionData
+	aux := struct {
+		*alias
+		Result json.RawMessage `json:"result"`
+	}{alias: (*alias)(e)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	value, err := decodeFloat(aux.Result)
+	e.Result = value
+	return err
+}
+
+func (t TaskData) MarshalJSON() ([]byte, error) {
+	type alias TaskData
+	return json.Marshal(struct {
+		alias
+		Arg1 interface{} `json:"arg1"`
+		Arg2 interface{} `json:"arg2"`
+	}{alias(t), encodeFloat(t.Arg1), encodeFloat(t.Arg2)})
+}
+
+func (t *TaskData) UnmarshalJSON(data []byte) error {
+	type alias TaskData
+	aux := struct {
+		*alias
+		Arg1 json.RawMessage `json:"arg1"`
+		Arg2 json.RawMessage `json:"arg2"`
+	}{alias: (*alias)(t)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if t.Arg1, err = decodeFloat(aux.Arg1); err != nil {
+		return err
+	}
+	t.Arg2, err = decodeFloat(aux.Arg2)
+	return err
+}
+
+func (r Result) MarshalJSON() ([]byte, error) {
+	type alias Result
+	return json.Marshal(struct {
+		alias
+		Result interface{} `json:"result"`
+	}{alias(r), encodeFloat(r.Result)})
+}
+
+func (r *Result) UnmarshalJSON(data []byte) error {
+	type alias Result
+	aux := struct {
+		*alias
+		Result json.RawMessage `json:"result"`
+	}{alias: (*alias)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	value, err := decodeFloat(aux.Result)
+	r.Result = value
+	return err
+}
+
+func (s Step) MarshalJSON() ([]byte, error) {
+	type alias Step
+	return json.Marshal(struct {
+		alias
+		Value interface{} `json:"value"`
+	}{alias(s), encodeFloat(s.Value)})
+}
+
+func (s *Step) UnmarshalJSON(data []byte) error {
+	type alias Step
+	aux := struct {
+		*alias
+		Value json.RawMessage `json:"value"`
+	}{alias: (*alias)(s)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	value, err := decodeFloat(aux.Value)
+	s.Value = value
+	return err
+}
+
+func (t TraceEntry) MarshalJSON() ([]byte, error) {
+	type alias TraceEntry
+	return json.Marshal(struct {
+		alias
+		Arg1   interface{} `json:"arg1"`
+		Arg2   interface{} `json:"arg2"`
+		Result interface{} `json:"result"`
+	}{alias(t), encodeFloat(t.Arg1), encodeFloat(t.Arg2), encodeFloat(t.Result)})
+}
+
+func (t *TraceEntry) UnmarshalJSON(data []byte) error {
+	type alias TraceEntry
+	aux := struct {
+		*alias
+		Arg1   json.RawMessage `json:"arg1"`
+		Arg2   json.RawMessage `json:"arg2"`
+		Result json.RawMessage `json:"result"`
+	}{alias: (*alias)(t)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if t.Arg1, err = decodeFloat(aux.Arg1); err != nil {
+		return err
+	}
+	if t.Arg2, err = decodeFloat(aux.Arg2); err != nil {
+		return err
+	}
+	t.Result, err = decodeFloat(aux.Result)
+	return err
+}
+
+func (r ScheduleRun) MarshalJSON() ([]byte, error) {
+	type alias ScheduleRun
+	return json.Marshal(struct {
+		alias
+		Result interface{} `json:"result"`
+	}{alias(r), encodeFloat(r.Result)})
+}
+
+func (r *ScheduleRun) UnmarshalJSON(data []byte) error {
+	type alias ScheduleRun
+	aux := struct {
+		*alias
+		Result json.RawMessage `json:"result"`
+	}{alias: (*alias)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	value, err := decodeFloat(aux.Result)
+	r.Result = value
+	return err
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/db/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Срабатывание расписания не захватывается, если запись уже удалена
+func TestCacheZClaim(t *testing.T) {
+	server := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.NoError(t, err)
+	client := cache.New(cache.RedisConfig{Host: host, Port: port})
+	ctx := context.Background()
+
+	require.NoError(t, client.ZAdd(ctx, "due", 100, "1"))
+	require.NoError(t, client.Set(ctx, "record:1", "old", 0))
+
+	next := float64(200)
+	claimed, err := client.ZClaim(ctx, "due", "1", 50, &next, "record:1", "new")
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	claimed, err = client.ZClaim(ctx, "due", "1", 100, &next, "record:1", "new")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	score, err := client.ZScore(ctx, "due", "1")
+	require.NoError(t, err)
+	assert.Equal(t, next, score)
+	value, err := client.Get(ctx, "record:1")
+	require.NoError(t, err)
+	assert.Equal(t, "new", value)
+
+	// Удалённая запись не создаётся заново, а её срабатывание снимается
+	require.NoError(t, client.Del(ctx, "record:1"))
+	claimed, err = client.ZClaim(ctx, "due", "1", 200, nil, "record:1", "new")
+	require.NoError(t, err)
+	assert.False(t, claimed)
+	assert.False(t, server.Exists("record:1"))
+	_, err = client.ZScore(ctx, "due", "1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/utils/cron"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для разбора cron-выражений и расчёта следующего запуска
+func TestCronNext(t *testing.T) {
+	// Среда, 15 октября 2025 года
+	from := time.Date(2025, time.October, 15, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		next time.Time
+	}{
+		{name: "Каждую минуту", spec: "* * * * *", next: time.Date(2025, 10, 15, 10, 18, 0, 0, time.UTC)},
+		{name: "Каждый час", spec: "@hourly", next: time.Date(2025, 10, 15, 11, 0, 0, 0, time.UTC)},
+		{name: "Шаг", spec: "*/15 * * * *", next: time.Date(2025, 10, 15, 10, 30, 0, 0, time.UTC)},
+		{name: "Шаг от значения", spec: "5/20 * * * *", next: time.Date(2025, 10, 15, 10, 25, 0, 0, time.UTC)},
+		{name: "Список и диапазон", spec: "0 9-17/4,20 * * *", next: time.Date(2025, 10, 15, 13, 0, 0, 0, time.UTC)},
+		{name: "Начало месяца", spec: "0 0 1 * *", next: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "Воскресенье как 7", spec: "30 6 * * 7", next: time.Date(2025, 10, 19, 6, 30, 0, 0, time.UTC)},
+		{name: "День месяца или недели", spec: "0 0 20 * 5", next: time.Date(2025, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{name: "Високосный год", spec: "0 0 29 2 *", next: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, schedule.Next(from))
+		})
+	}
+
+	schedule, err := cron.Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(from).IsZero())
+}
+
+func TestCronParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		_, err := cron.Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/hash"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для позиционных ошибок разбора
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		code       string
+		offset     int
+		token      string
+	}{
+		{name: "Пустое выражение", expression: "  ", code: errors.CodeEmptyExpression, offset: 0},
+		{name: "Двойной оператор", expression: "2++2", code: errors.CodeUnexpectedToken, offset: 2, token: "+"},
+		{name: "Неподдерживаемые символы", expression: "2+a", code: errors.CodeUnknownOperation, offset: 2, token: "a"},
+		{name: "Незакрытая скобка", expression: "1+(2+2", code: errors.CodeUnbalancedParen, offset: 2, token: "("},
+		{name: "Лишняя скобка", expression: "2+2)", code: errors.CodeUnbalancedParen, offset: 3, token: ")"},
+		{name: "Обрыв выражения", expression: "2*", code: errors.CodeUnexpectedEnd, offset: 2},
+		{name: "Некорректное число", expression: "1.2.3+1", code: errors.CodeInvalidNumber, offset: 0, token: "1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.expression)
+			require.Error(t, err)
+
+			body := errors.NewBody(err)
+			assert.Equal(t, tt.code, body.Code)
+			require.NotNil(t, body.Offset)
+			assert.Equal(t, tt.offset, *body.Offset)
+			assert.Equal(t, tt.token, body.Token)
+			assert.Equal(t, http.StatusUnprocessableEntity, errors.Status(err))
+		})
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, errors.Status(errors.ErrNotFound))
+	assert.Equal(t, http.StatusUnauthorized, errors.Status(errors.ErrInvalidCredentials))
+	assert.Equal(t, http.StatusBadRequest, errors.Status(fmt.Errorf("%w: bad json", errors.ErrInvalidRequest)))
+	assert.Equal(t, http.StatusInternalServerError, errors.Status(fmt.Errorf("redis is down")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, errors.Status(fmt.Errorf("%w: 2000 characters", errors.ErrExpressionTooLong)))
+	assert.Equal(t, http.StatusUnprocessableEntity, errors.Status(errors.ErrTooManyTasks))
+	assert.Equal(t, http.StatusForbidden, errors.Status(errors.ErrForbidden))
+	assert.Equal(t, http.StatusTooManyRequests, errors.Status(&errors.RetryError{Err: errors.ErrQueueFull, After: time.Second}))
+
+	status, response := errors.NewResponse(fmt.Errorf("redis is down"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, errors.CodeInternal, response.Error.Code)
+	assert.Nil(t, response.Error.Offset)
+}
+
+// Задержка повтора доступна и у обёрнутой ошибки
+func TestRetryAfter(t *testing.T) {
+	err := fmt.Errorf("submit: %w", &errors.RetryError{Err: errors.ErrQueueFull, After: 1500 * time.Millisecond})
+	assert.ErrorIs(t, err, errors.ErrQueueFull)
+	assert.Equal(t, 1500*time.Millisecond, errors.RetryAfter(err))
+	assert.Equal(t, errors.CodeTaskQueueFull, errors.Code(err))
+	assert.Zero(t, errors.RetryAfter(errors.ErrNotFound))
+}
+
+// Ошибка с несколькими известными причинами всегда получает один и тот же код
+func TestErrorCodesStable(t *testing.T) {
+	err := &errors.RetryError{Err: fmt.Errorf("%w: %w", errors.ErrInvalidExpression, errors.ErrUnexpectedEnd), After: time.Second}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, errors.CodeUnexpectedEnd, errors.Code(err))
+	}
+	assert.Equal(t, errors.ErrUnexpectedEnd, errors.FromCode(errors.CodeUnexpectedEnd))
+}
+
+// Неверный пароль — не ошибка сравнения, а повреждённый хеш — ошибка
+func TestCheckStringHash(t *testing.T) {
+	hashed, err := hash.HashString("secret")
+	require.NoError(t, err)
+
+	ok, err := hash.CheckStirngHash("secret", hashed)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = hash.CheckStirngHash("wrong", hashed)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = hash.CheckStirngHash("secret", "corrupt")
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
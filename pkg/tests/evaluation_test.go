@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xKARASb/Calculator/internal/orchestrator/repository"
+	"github.com/xKARASb/Calculator/pkg/db/cache"
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Репозиторий поверх miniredis; Postgres не нужен, пока выражения
+// отправляются от пользователя без ID
+func newTestRepository(t *testing.T, cfg repository.CalculatorRepositoryConfig) *repository.CalculatorRepository {
+	server := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return repository.NewCalculatorRepository(ctx, cfg, nil, cache.New(cache.RedisConfig{Host: host, Port: port}))
+}
+
+// Берёт задачу от имени агента, когда она появится в очереди
+func takeTask(t *testing.T, repo *repository.CalculatorRepository, agentID string) models.TaskData {
+	var task *models.Task
+	require.Eventually(t, func() bool {
+		var err error
+		task, err = repo.NextTask(agentID)
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	return task.Task
+}
+
+// Выполняет одну задачу от имени агента
+func completeTask(t *testing.T, repo *repository.CalculatorRepository, agentID string) {
+	task := takeTask(t, repo, agentID)
+
+	var result float64
+	switch task.Operation {
+	case "+":
+		result = task.Arg1 + task.Arg2
+	case "-":
+		result = task.Arg1 - task.Arg2
+	case "*":
+		result = task.Arg1 * task.Arg2
+	case "/":
+		result = task.Arg1 / task.Arg2
+	}
+	require.NoError(t, repo.SetTaskResult(agentID, models.Result{ID: task.ID, Result: result}))
+}
+
+// Ждёт, пока выражение придёт в нужное состояние
+func waitExpression(t *testing.T, repo *repository.CalculatorRepository, id int, ready func(models.ExpressionData) bool) models.ExpressionData {
+	var data models.ExpressionData
+	require.Eventually(t, func() bool {
+		expr, err := repo.GetExpressionByID(id)
+		if err != nil {
+			return false
+		}
+		data = expr.Expression
+		return ready(data)
+	}, 2*time.Second, 5*time.Millisecond)
+	return data
+}
+
+// Прогресс и оставшееся время обновляются по мере выполнения задач
+func TestEvaluationProgress(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{})
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	response, err := repo.Submit(models.User{}, models.Request{Expression: "(1+2)*(3+4)"})
+	require.NoError(t, err)
+
+	expr := waitExpression(t, repo, response.ID, func(e models.ExpressionData) bool {
+		return e.Status == statuses.StatusProgress
+	})
+	assert.Equal(t, 3, expr.TasksTotal)
+	assert.Zero(t, expr.TasksDone)
+	assert.Zero(t, expr.Progress)
+	assert.Positive(t, expr.EtaMS)
+	eta := expr.EtaMS
+
+	completeTask(t, repo, "agent")
+	expr = waitExpression(t, repo, response.ID, func(e models.ExpressionData) bool {
+		return e.TasksDone == 1
+	})
+	assert.InDelta(t, 1.0/3, expr.Progress, 1e-9)
+	assert.Positive(t, expr.EtaMS)
+	assert.Less(t, expr.EtaMS, eta)
+
+	completeTask(t, repo, "agent")
+	completeTask(t, repo, "agent")
+	expr = waitExpression(t, repo, response.ID, func(e models.ExpressionData) bool {
+		return e.Status == statuses.StatusComplete
+	})
+	assert.Equal(t, 3, expr.TasksDone)
+	assert.Equal(t, float64(1), expr.Progress)
+	assert.Zero(t, expr.EtaMS)
+	assert.Equal(t, float64(21), expr.Result)
+}
+
+// Истёкший срок останавливает выражение и снимает оставшиеся задачи с очереди
+func TestEvaluationTimeout(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{})
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	response, err := repo.Submit(models.User{}, models.Request{Expression: "(1+2)*(3+4)", TimeoutMS: 200})
+	require.NoError(t, err)
+
+	completeTask(t, repo, "agent")
+	expr := waitExpression(t, repo, response.ID, func(e models.ExpressionData) bool {
+		return e.Status == statuses.StatusTimeout
+	})
+	assert.Equal(t, 1, expr.TasksDone)
+	assert.GreaterOrEqual(t, expr.ElapsedMS, int64(200))
+	require.NotNil(t, expr.Error)
+	assert.Equal(t, errors.CodeDeadlineExceeded, expr.Error.Code)
+	assert.NotNil(t, expr.Deadline)
+
+	_, err = repo.NextTask("agent")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+}
+
+// Временный сбой агента повторяет задачу, а история попыток попадает в трассировку
+func TestEvaluationRetryTrace(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{RetryMaxAttempts: 3, RetryBackoff: time.Millisecond})
+	require.NoError(t, repo.RegisterAgent("flaky", models.Capabilities{}))
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	response, err := repo.Submit(models.User{}, models.Request{Expression: "1+2"})
+	require.NoError(t, err)
+
+	task := takeTask(t, repo, "flaky")
+	require.NoError(t, repo.SetTaskResult("flaky", models.Result{ID: task.ID, Error: errors.CodeAgentFailure}))
+
+	completeTask(t, repo, "agent")
+	expr := waitExpression(t, repo, response.ID, func(e models.ExpressionData) bool {
+		return e.Status == statuses.StatusComplete
+	})
+	assert.Equal(t, float64(3), expr.Result)
+
+	trace, err := repo.GetTrace(response.ID)
+	require.NoError(t, err)
+	require.Len(t, trace.Tasks, 1)
+	entry := trace.Tasks[0]
+	assert.Equal(t, "agent", entry.Agent)
+	require.Len(t, entry.Attempts, 2)
+	assert.Equal(t, "flaky", entry.Attempts[0].Agent)
+	assert.Equal(t, errors.CodeAgentFailure, entry.Attempts[0].Error)
+	assert.Equal(t, "agent", entry.Attempts[1].Agent)
+	assert.Empty(t, entry.Attempts[1].Error)
+}
+
+// Живыми считаются только зарегистрированные агенты, которые забирают задачи
+func TestEvaluationAgentHeartbeat(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{})
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	live, err := repo.LiveAgents()
+	require.NoError(t, err)
+	assert.Zero(t, live)
+
+	_, err = repo.NextTask("ghost")
+	assert.ErrorIs(t, err, errors.ErrAgentNotRegistered)
+	_, err = repo.NextTask("agent")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	live, err = repo.LiveAgents()
+	require.NoError(t, err)
+	assert.Equal(t, 1, live)
+}
+
+// Допущенное выражение занимает место в очереди под все свои задачи сразу
+func TestEvaluationAdmissionReserve(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{QueueLimit: 3})
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	first, err := repo.Submit(models.User{}, models.Request{Expression: "(1+2)*(3+4)"})
+	require.NoError(t, err)
+
+	_, err = repo.Submit(models.User{}, models.Request{Expression: "(5+6)*(7+8)"})
+	assert.ErrorIs(t, err, errors.ErrQueueFull)
+
+	for i := 0; i < 3; i++ {
+		completeTask(t, repo, "agent")
+	}
+	waitExpression(t, repo, first.ID, func(e models.ExpressionData) bool {
+		return e.Status == statuses.StatusComplete
+	})
+
+	_, err = repo.Submit(models.User{}, models.Request{Expression: "(5+6)*(7+8)"})
+	assert.NoError(t, err)
+}
+
+// Недоставленная задача отменённого выражения не возвращается в очередь, а её запись удаляется
+func TestEvaluationDeadLetterGone(t *testing.T) {
+	repo := newTestRepository(t, repository.CalculatorRepositoryConfig{RetryMaxAttempts: 1})
+	require.NoError(t, repo.RegisterAgent("agent", models.Capabilities{}))
+
+	response, err := repo.Submit(models.User{}, models.Request{Expression: "1+2"})
+	require.NoError(t, err)
+
+	task := takeTask(t, repo, "agent")
+	require.NoError(t, repo.SetTaskResult("agent", models.Result{ID: task.ID, Error: errors.CodeAgentFailure}))
+
+	var letters *models.DeadLetters
+	require.Eventually(t, func() bool {
+		letters, err = repo.ListDeadLetters()
+		return err == nil && len(letters.DeadLetters) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	_, err = repo.Cancel(models.User{}, response.ID)
+	require.NoError(t, err)
+
+	_, err = repo.RequeueDeadLetter(letters.DeadLetters[0].ID)
+	assert.ErrorIs(t, err, errors.ErrDeadLetterGone)
+	assert.Equal(t, http.StatusGone, errors.Status(err))
+
+	letters, err = repo.ListDeadLetters()
+	require.NoError(t, err)
+	assert.Empty(t, letters.DeadLetters)
+}
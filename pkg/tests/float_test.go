@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/statuses"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для бесконечностей и NaN в JSON
+func TestNonFiniteRoundTrip(t *testing.T) {
+	for _, value := range []float64{math.Inf(1), math.Inf(-1), math.NaN(), 4} {
+		expr := models.Expression{Expression: models.ExpressionData{ID: 1, Status: statuses.StatusComplete, Result: value}}
+
+		data, err := json.Marshal(expr)
+		require.NoError(t, err)
+
+		var decoded models.Expression
+		require.NoError(t, json.Unmarshal(data, &decoded))
+
+		if math.IsNaN(value) {
+			assert.True(t, math.IsNaN(decoded.Expression.Result))
+			assert.Contains(t, string(data), `"result":"NaN"`)
+			continue
+		}
+		assert.Equal(t, value, decoded.Expression.Result)
+		assert.Equal(t, 1, decoded.Expression.ID)
+	}
+
+	data, err := json.Marshal(models.Result{ID: 2, Result: math.Inf(1), Error: errors.CodeOverflow})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":2,"result":"+Inf","error":"OVERFLOW"}`, string(data))
+
+	var task models.Task
+	require.NoError(t, json.Unmarshal([]byte(`{"task":{"id":3,"arg1":"-Inf","arg2":2,"operation":"*"}}`), &task))
+	assert.True(t, math.IsInf(task.Task.Arg1, -1))
+	assert.Equal(t, float64(2), task.Task.Arg2)
+
+	data, err = json.Marshal(models.ScheduleRun{ExpressionID: 4, Status: statuses.StatusComplete, Result: math.NaN()})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"result":"NaN"`)
+
+	var run models.ScheduleRun
+	require.NoError(t, json.Unmarshal(data, &run))
+	assert.True(t, math.IsNaN(run.Result))
+	assert.Equal(t, 4, run.ExpressionID)
+}
+
+func TestParserOverflowLiteral(t *testing.T) {
+	_, err := parser.Parse("1e400*2")
+	require.Error(t, err)
+	assert.Equal(t, errors.CodeOverflow, errors.Code(err))
+
+	node, err := parser.Parse("1e308*10")
+	require.NoError(t, err)
+	assert.Equal(t, 1e308, node.Left.Value)
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/format"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func round(n int) *int {
+	return &n
+}
+
+// Тесты для форматирования результата
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		options  format.Options
+		expected string
+	}{
+		{2.5, format.Options{}, "2.5"},
+		{2.675, format.Options{Round: round(2), Rounding: format.RoundingHalfUp}, "2.68"},
+		{2.665, format.Options{Round: round(2)}, "2.66"},
+		{2.675, format.Options{Round: round(2)}, "2.68"},
+		{-2.679, format.Options{Round: round(2), Rounding: format.RoundingTruncate}, "-2.67"},
+		{-2.5, format.Options{Round: round(0), Rounding: format.RoundingHalfUp}, "-3"},
+		{1, format.Options{Round: round(3), Format: format.FormatFixed}, "1.000"},
+		{12345.678, format.Options{Format: format.FormatScientific}, "1.2345678e+04"},
+		{12345.678, format.Options{Round: round(2), Format: format.FormatScientific}, "1.23e+04"},
+		{9.999, format.Options{Round: round(2), Format: format.FormatScientific}, "1.00e+01"},
+		{0.00012, format.Options{Format: format.FormatScientific}, "1.2e-04"},
+		{12345.678, format.Options{Round: round(1), Format: format.FormatEngineering}, "12.3e+03"},
+		{0.00012, format.Options{Format: format.FormatEngineering}, "120e-06"},
+		{999999, format.Options{Round: round(0), Format: format.FormatEngineering}, "1e+06"},
+		{0, format.Options{Format: format.FormatEngineering}, "0e+00"},
+		{0.75, format.Options{Format: format.FormatFraction}, "3/4"},
+		{-3.5, format.Options{Format: format.FormatFraction}, "-7/2"},
+		{4, format.Options{Format: format.FormatFraction}, "4"},
+		{1.0 / 3, format.Options{Format: format.FormatFraction}, "1/3"},
+		{math.Pi, format.Options{Round: round(2), Format: format.FormatFraction}, "311/99"},
+		{math.Inf(1), format.Options{Round: round(2)}, "+Inf"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, format.Format(tt.value, tt.options), "%v %+v", tt.value, tt.options)
+	}
+}
+
+// Тесты для проверки параметров форматирования
+func TestFormatValidate(t *testing.T) {
+	assert.NoError(t, format.Validate(format.Options{}))
+	assert.NoError(t, format.Validate(format.Options{Round: round(2), Format: format.FormatFraction, Rounding: format.RoundingTruncate}))
+
+	for _, options := range []format.Options{
+		{Round: round(-1)},
+		{Round: round(18)},
+		{Format: "roman"},
+		{Rounding: "ceil"},
+	} {
+		err := format.Validate(options)
+		assert.ErrorIs(t, err, errors.ErrInvalidFormat)
+		assert.Equal(t, errors.CodeInvalidFormat, errors.Code(err))
+	}
+}
+
+// Тесты для переопределения сохраненных параметров
+func TestFormatMerge(t *testing.T) {
+	saved := format.Options{Round: round(2), Format: format.FormatScientific}
+	merged := saved.Merge(format.Options{Format: format.FormatFixed})
+
+	assert.Equal(t, 2, *merged.Round)
+	assert.Equal(t, format.FormatFixed, merged.Format)
+}
+
+// Тесты для параметров форматирования в запросе
+func TestRequestFormatOptions(t *testing.T) {
+	var request models.Request
+	require.NoError(t, json.Unmarshal([]byte(`{"expression":"1/3","round":2,"format":"fixed","rounding":"half_up"}`), &request))
+
+	assert.Equal(t, "1/3", request.Expression)
+	assert.Equal(t, 2, *request.Round)
+	assert.Equal(t, format.FormatFixed, request.Format)
+	assert.Equal(t, format.RoundingHalfUp, request.Rounding)
+}
@@ -72,7 +72,7 @@ func setupIntegrationServer(t *testing.T) (*integrationServer, func()) {
 
 	// Инициализация репозитория и сервиса
 	ctx := context.Background()
-	repo := repository.NewCalculatorRepository(ctx, db, redisClient)
+	repo := repository.NewCalculatorRepository(ctx, repository.CalculatorRepositoryConfig{}, db, redisClient)
 	srv := service.NewCalculatorService(repo)
 
 	// Настройка сервера Echo
@@ -102,7 +102,7 @@ func setupIntegrationServer(t *testing.T) (*integrationServer, func()) {
 	var agentStopped bool
 	agentStop := make(chan struct{})
 	go func() {
-		a := agent.NewAgent(1)
+		a := agent.NewAgent(1, "localhost")
 		go func() {
 			<-agentStop
 			agentStopped = true
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для постфиксной и префиксной записи
+func TestNotationSameTree(t *testing.T) {
+	tests := []struct {
+		infix  string
+		rpn    string
+		prefix string
+	}{
+		{"(10+2)*2-3", "10 2 + 2 * 3 -", "- * + 10 2 2 3"},
+		{"2-(3-4)", "2 3 4 - -", "- 2 - 3 4"},
+		{"-5*2", "-5 2 *", "* -5 2"},
+		{"-(2+3)/4", "2 3 + neg 4 /", "/ neg + 2 3 4"},
+		{"1.5e3 - 7", "1.5e3 7 -", "- 1.5e3 7"},
+	}
+
+	for _, tt := range tests {
+		infix, err := parser.ParseNotation(tt.infix, parser.NotationInfix)
+		require.NoError(t, err, tt.infix)
+		rpn, err := parser.ParseNotation(tt.rpn, parser.NotationRPN)
+		require.NoError(t, err, tt.rpn)
+		prefix, err := parser.ParseNotation(tt.prefix, parser.NotationPrefix)
+		require.NoError(t, err, tt.prefix)
+
+		assert.Equal(t, parser.Canonical(infix), parser.Canonical(rpn))
+		assert.Equal(t, parser.Canonical(infix), parser.Canonical(prefix))
+		assert.Equal(t, parser.Analyze(infix), parser.Analyze(rpn))
+		assert.Equal(t, parser.Analyze(infix), parser.Analyze(prefix))
+	}
+}
+
+func TestNotationPrint(t *testing.T) {
+	node, err := parser.Parse("(10+2)*2 - -(1/4)")
+	require.NoError(t, err)
+
+	assert.Equal(t, "10 2 + 2 * 1 4 / neg -", parser.ToRPN(node))
+	assert.Equal(t, "- * + 10 2 2 neg / 1 4", parser.ToPrefix(node))
+
+	rpn, err := parser.ParseRPN(parser.ToRPN(node))
+	require.NoError(t, err)
+	assert.Equal(t, parser.Canonical(node), parser.Canonical(rpn))
+
+	prefix, err := parser.ParsePrefix(parser.ToPrefix(node))
+	require.NoError(t, err)
+	assert.Equal(t, parser.Canonical(node), parser.Canonical(prefix))
+}
+
+func TestNotationInvalid(t *testing.T) {
+	tests := []struct {
+		notation   string
+		expression string
+		code       string
+		offset     int
+	}{
+		{parser.NotationRPN, "", errors.CodeEmptyExpression, 0},
+		{parser.NotationRPN, "2 +", errors.CodeUnexpectedToken, 2},
+		{parser.NotationRPN, "2 3", errors.CodeUnexpectedEnd, 3},
+		{parser.NotationRPN, "2 3 ^", errors.CodeUnknownOperation, 4},
+		{parser.NotationRPN, "( 2 3 + )", errors.CodeUnexpectedToken, 0},
+		{parser.NotationPrefix, "+ 2", errors.CodeUnexpectedEnd, 3},
+		{parser.NotationPrefix, "+ 2 3 4", errors.CodeUnexpectedToken, 6},
+		{parser.NotationPrefix, "neg", errors.CodeUnexpectedEnd, 3},
+	}
+
+	for _, tt := range tests {
+		_, err := parser.ParseNotation(tt.expression, tt.notation)
+		require.Error(t, err, tt.expression)
+
+		body := errors.NewBody(err)
+		assert.Equal(t, tt.code, body.Code, tt.expression)
+		require.NotNil(t, body.Offset, tt.expression)
+		assert.Equal(t, tt.offset, *body.Offset, tt.expression)
+	}
+
+	_, err := parser.ParseNotation("2 2 +", "lisp")
+	assert.Equal(t, errors.CodeInvalidNotation, errors.Code(err))
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/timings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для разбора выражений
+func TestParserParse(t *testing.T) {
+	node, err := parser.Parse("2+2*2")
+	require.NoError(t, err)
+
+	assert.Equal(t, parser.TypeBinary, node.Type)
+	assert.Equal(t, "+", node.Token)
+	assert.Equal(t, 1, node.Offset)
+	assert.Equal(t, float64(2), node.Left.Value)
+	assert.Equal(t, "*", node.Right.Token)
+}
+
+func TestParserUnaryMinus(t *testing.T) {
+	node, err := parser.Parse("-5+3")
+	require.NoError(t, err)
+	assert.Equal(t, float64(-5), node.Left.Value)
+
+	node, err = parser.Parse("-(2+3)")
+	require.NoError(t, err)
+	assert.Equal(t, parser.TypeUnary, node.Type)
+	assert.Equal(t, "+", node.Operand.Token)
+}
+
+func TestParserInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "Пустое выражение", expression: ""},
+		{name: "Двойной оператор", expression: "2++2"},
+		{name: "Неподдерживаемые символы", expression: "2+a"},
+		{name: "Незакрытая скобка", expression: "(2+2"},
+		{name: "Лишняя скобка", expression: "2+2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.Parse(tt.expression)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// Тесты для оценки стоимости выражения
+func TestParserAnalyze(t *testing.T) {
+	node, err := parser.Parse("(1+2)*(3-4)/5")
+	require.NoError(t, err)
+
+	stats := parser.Analyze(node)
+
+	assert.Equal(t, 4, stats.Tasks)
+	assert.Equal(t, map[string]int{"+": 1, "-": 1, "*": 1, "/": 1}, stats.Operations)
+	assert.Equal(t, 3, stats.CriticalPath)
+	assert.Equal(t, timings.TimeAdditionMS+timings.TimeMultiplicationMS+timings.TimeDivisionMS, stats.CriticalPathMS)
+}
+
+func TestParserEstimate(t *testing.T) {
+	node, err := parser.Parse("(1+2)*(3+4)")
+	require.NoError(t, err)
+
+	// Один агент выполняет задачи последовательно
+	assert.Equal(t, 2*timings.TimeAdditionMS+timings.TimeMultiplicationMS, parser.Estimate(node, 1))
+	// Два агента складывают параллельно
+	assert.Equal(t, timings.TimeAdditionMS+timings.TimeMultiplicationMS, parser.Estimate(node, 2))
+	// Без операций выражение вычисляется мгновенно
+	node, err = parser.Parse("42")
+	require.NoError(t, err)
+	assert.Equal(t, 0, parser.Estimate(node, 4))
+}
+
+// Тесты для канонической формы выражения
+func TestParserCanonical(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+	}{
+		{expression: "2 + 2*2", canonical: "2 + 2 * 2"},
+		{expression: "2+2 * 2", canonical: "2 + 2 * 2"},
+		{expression: "(2+2)*2", canonical: "(2 + 2) * 2"},
+		{expression: "((1-2))-3", canonical: "1 - 2 - 3"},
+		{expression: "1-(2-3)", canonical: "1 - (2 - 3)"},
+		{expression: "8/(4*2)", canonical: "8 / (4 * 2)"},
+		{expression: "2.50*3", canonical: "2.5 * 3"},
+		{expression: "-(1+2)*-3", canonical: "-(1 + 2) * -3"},
+		{expression: "1e3+1", canonical: "1000 + 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			node, err := parser.Parse(tt.expression)
+			require.NoError(t, err)
+			assert.Equal(t, tt.canonical, parser.Canonical(node))
+
+			// Каноническая форма разбирается в то же дерево
+			again, err := parser.Parse(parser.Canonical(node))
+			require.NoError(t, err)
+			assert.Equal(t, tt.canonical, parser.Canonical(again))
+		})
+	}
+}
+
+// Глубокая вложенность отклоняется во время разбора, а не переполняет стек
+func TestParserDepth(t *testing.T) {
+	_, err := parser.Parse(strings.Repeat("(", 1000000) + "1" + strings.Repeat(")", 1000000))
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+
+	_, err = parser.Parse(strings.Repeat("-", 1000000) + "1")
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+
+	_, err = parser.ParseNotationDepth("((1+2))", parser.NotationInfix, 2)
+	assert.NoError(t, err)
+	_, err = parser.ParseNotationDepth("(((1+2)))", parser.NotationInfix, 2)
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+
+	_, err = parser.ParseNotationDepth("1 2 + 3 + 4 +", parser.NotationRPN, 2)
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+	_, err = parser.ParseNotationDepth("1 2 + 3 4 + +", parser.NotationRPN, 2)
+	assert.NoError(t, err)
+
+	_, err = parser.ParseNotationDepth("+ + + 1 2 3 4", parser.NotationPrefix, 2)
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+	_, err = parser.ParsePrefix(strings.Repeat("+ ", 100000) + strings.Repeat("1 ", 100001))
+	assert.ErrorIs(t, err, errors.ErrExpressionTooDeep)
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для вывода выражения в LaTeX и MathML
+func TestRenderLaTeX(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"(10+2)*2-3", `\left(10 + 2\right) \cdot 2 - 3`},
+		{"2-(3-4)", `2 - \left(3 - 4\right)`},
+		{"(2-3)-4", `2 - 3 - 4`},
+		{"(1+2)/(3*4)", `\frac{1 + 2}{3 \cdot 4}`},
+		{"1/2*3", `\frac{1}{2} \cdot 3`},
+		{"2 - -3", `2 - \left(-3\right)`},
+		{"-(2+3)*4", `-\left(2 + 3\right) \cdot 4`},
+		{"-(-(1+2))", `-\left(-\left(1 + 2\right)\right)`},
+		{"1e25+1", `1 \times 10^{25} + 1`},
+	}
+
+	for _, tt := range tests {
+		node, err := parser.Parse(tt.expression)
+		require.NoError(t, err)
+
+		rendered, err := parser.Render(node, parser.RenderLaTeX, nil)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, rendered, tt.expression)
+	}
+}
+
+func TestRenderResult(t *testing.T) {
+	node, err := parser.Parse("(10+2)/4")
+	require.NoError(t, err)
+
+	result := 3.0
+	rendered, err := parser.Render(node, parser.RenderLaTeX, &result)
+	require.NoError(t, err)
+	assert.Equal(t, `\frac{10 + 2}{4} = 3`, rendered)
+
+	rendered, err = parser.Render(node, parser.RenderMathML, &result)
+	require.NoError(t, err)
+	assert.Equal(t, `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>`+
+		`<mfrac><mrow><mn>10</mn><mo>+</mo><mn>2</mn></mrow><mrow><mn>4</mn></mrow></mfrac>`+
+		`<mo>=</mo><mn>3</mn></mrow></math>`, rendered)
+
+	_, err = parser.Render(node, "svg", nil)
+	assert.Equal(t, errors.CodeInvalidRender, errors.Code(err))
+}
@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xKARASb/Calculator/internal/orchestrator/repository"
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/retry"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для политики повторов
+func TestRetryPolicy(t *testing.T) {
+	policy, err := retry.Parse("4/100ms/0")
+	require.NoError(t, err)
+	assert.Equal(t, 4, policy.Attempts())
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		delay := policy.Delay(2)
+		assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		assert.LessOrEqual(t, delay, 300*time.Millisecond)
+	}
+
+	_, err = retry.Parse("4/100ms")
+	assert.Error(t, err)
+
+	policies, err := retry.ParsePolicies(retry.Policy{MaxAttempts: 3}, map[string]string{"/": "1/0s/0"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, policies.For("/").Attempts())
+	assert.Equal(t, 3, policies.For("+").Attempts())
+}
+
+func retryQueue(attempts int) *repository.TaskQueue {
+	queue := repository.NewTaskQueue(0)
+	queue.SetRetryPolicy(retry.Policies{Default: retry.Policy{MaxAttempts: attempts, Backoff: time.Millisecond}}, time.Second)
+	return queue
+}
+
+func nextTask(t *testing.T, queue *repository.TaskQueue, agent string) *models.Task {
+	for i := 0; i < 100; i++ {
+		task, err := queue.Next(agent)
+		if err == nil {
+			return task
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("task was not requeued")
+	return nil
+}
+
+func TestTaskQueueRetry(t *testing.T) {
+	queue := retryQueue(3)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+
+	// Сбой агента повторяется на другом агенте
+	retried := nextTask(t, queue, "agent-2")
+	assert.Equal(t, task.Task.ID, retried.Task.ID)
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 5}))
+
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, float64(5), outcome.Result)
+	assert.Equal(t, "agent-2", outcome.Agent)
+	require.Len(t, outcome.Attempts, 2)
+	assert.Equal(t, "agent-1", outcome.Attempts[0].Agent)
+	assert.Equal(t, errors.CodeAgentFailure, outcome.Attempts[0].Error)
+	assert.Empty(t, outcome.Attempts[1].Error)
+	assert.Equal(t, int64(1), queue.Stats().Retried)
+}
+
+// Временный сбой от агента прошлой попытки не прерывает текущую попытку
+func TestTaskQueueStaleFailure(t *testing.T) {
+	queue := retryQueue(3)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+	require.NoError(t, queue.CompleteBy("agent-1", models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+	nextTask(t, queue, "agent-2")
+
+	err := queue.CompleteBy("agent-1", models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure})
+	assert.ErrorIs(t, err, errors.ErrTaskCancelled)
+	assert.Equal(t, 1, queue.Stats().Running)
+	assert.Equal(t, int64(1), queue.Stats().Retried)
+
+	require.NoError(t, queue.CompleteBy("agent-2", models.Result{ID: task.Task.ID, Result: 5}))
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, "agent-2", outcome.Agent)
+	require.Len(t, outcome.Attempts, 2)
+	assert.Empty(t, outcome.Attempts[1].Error)
+}
+
+// Поздний успешный ответ от агента прошлой попытки засчитывается
+func TestTaskQueueStaleResult(t *testing.T) {
+	queue := retryQueue(3)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+	queue.Reap(time.Now().Add(time.Minute))
+	nextTask(t, queue, "agent-2")
+
+	require.NoError(t, queue.CompleteBy("agent-1", models.Result{ID: task.Task.ID, Result: 5}))
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, float64(5), outcome.Result)
+}
+
+func TestTaskQueueRetryExhausted(t *testing.T) {
+	queue := retryQueue(2)
+
+	done := queue.Enqueue(2, 3, "+")
+	for _, agent := range []string{"agent-1", "agent-2"} {
+		task := nextTask(t, queue, agent)
+		require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+	}
+
+	outcome := <-done
+	assert.ErrorIs(t, outcome.Err, errors.ErrAgentFailure)
+	assert.Len(t, outcome.Attempts, 2)
+}
+
+func TestTaskQueueNoRetryDeterministic(t *testing.T) {
+	queue := retryQueue(3)
+
+	// Деление на ноль не повторяется
+	done := queue.Enqueue(1, 0, "/")
+	task := nextTask(t, queue, "agent-1")
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeDivisionByZero}))
+
+	outcome := <-done
+	assert.ErrorIs(t, outcome.Err, errors.ErrDivisionByZero)
+	assert.Len(t, outcome.Attempts, 1)
+	assert.Equal(t, int64(0), queue.Stats().Retried)
+}
+
+func TestTaskQueueReap(t *testing.T) {
+	queue := retryQueue(2)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+
+	queue.Reap(time.Now())
+	assert.Equal(t, 1, queue.Stats().Running)
+
+	// Агент молчит дольше аренды — задача отдаётся другому
+	queue.Reap(time.Now().Add(time.Minute))
+	assert.Equal(t, int64(1), queue.Stats().Expired)
+
+	retried := nextTask(t, queue, "agent-2")
+	assert.Equal(t, task.Task.ID, retried.Task.ID)
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 5}))
+
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, errors.CodeLeaseExpired, outcome.Attempts[0].Error)
+}
+
+func deadLetterQueue() (*repository.TaskQueue, chan models.DeadLetter) {
+	letters := make(chan models.DeadLetter, 1)
+	queue := retryQueue(1)
+	queue.SetDeadLetter(func(letter models.DeadLetter) { letters <- letter })
+	return queue, letters
+}
+
+// Тесты для задач, исчерпавших попытки
+func TestTaskQueueDeadLetterRequeue(t *testing.T) {
+	queue, letters := deadLetterQueue()
+
+	done := queue.EnqueueFor(repository.TaskOwner{Expression: 7}, 2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+
+	letter := <-letters
+	assert.Equal(t, task.Task.ID, letter.Task.ID)
+	assert.Equal(t, errors.CodeAgentFailure, letter.Error)
+	assert.Equal(t, "agent-1", letter.Agent)
+	assert.Equal(t, 1, letter.Attempts)
+	assert.Equal(t, []int{7}, letter.Expressions)
+	assert.Len(t, done, 0)
+	assert.Equal(t, 1, queue.Stats().Dead)
+
+	// После возврата в очередь задача получает новые попытки
+	require.NoError(t, queue.Requeue(task.Task.ID))
+	assert.ErrorIs(t, queue.Requeue(task.Task.ID), errors.ErrNotFound)
+
+	retried := nextTask(t, queue, "agent-2")
+	require.NoError(t, queue.Complete(models.Result{ID: retried.Task.ID, Result: 5}))
+
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, float64(5), outcome.Result)
+	assert.Len(t, outcome.Attempts, 2)
+}
+
+func TestTaskQueueDeadLetterDiscard(t *testing.T) {
+	queue, letters := deadLetterQueue()
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "agent-1")
+	queue.Reap(time.Now().Add(time.Minute))
+	<-letters
+
+	// Новая такая же задача не ждёт мёртвую
+	queue.Enqueue(2, 3, "+")
+	assert.Equal(t, 1, queue.Stats().Pending)
+
+	require.NoError(t, queue.Discard(task.Task.ID))
+	assert.ErrorIs(t, (<-done).Err, errors.ErrLeaseExpired)
+	assert.Equal(t, 0, queue.Stats().Dead)
+}
+
+// Задача, ждущая повтора, не считается ни ожидающей, ни выполняемой
+func TestTaskQueueRetryStats(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	queue.SetRetryPolicy(retry.Policies{Default: retry.Policy{MaxAttempts: 3, Backoff: time.Minute}}, time.Second)
+
+	queue.Enqueue(2, 3, "+")
+	queue.Enqueue(4, 5, "+")
+	task := nextTask(t, queue, "agent-1")
+	nextTask(t, queue, "agent-2")
+	assert.Equal(t, 2, queue.Stats().Running)
+
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+	stats := queue.Stats()
+	assert.Equal(t, 1, stats.Running)
+	assert.Zero(t, stats.Pending)
+}
@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/utils/locale"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для подстановки промежуточных значений
+func TestSubstitute(t *testing.T) {
+	node, err := parser.Parse("(10+2)*2-3")
+	require.NoError(t, err)
+
+	sum := node.Left.Left
+	values := map[*parser.Node]float64{sum: 12}
+	assert.Equal(t, "12 * 2 - 3", parser.Canonical(parser.Substitute(node, values)))
+	assert.Equal(t, "(10 + 2) * 2 - 3", parser.Canonical(node))
+
+	values[node.Left] = 24
+	assert.Equal(t, "24 - 3", parser.Canonical(parser.Substitute(node, values)))
+
+	node, err = parser.Parse("-(1-3)")
+	require.NoError(t, err)
+	assert.Equal(t, "-(-2)", parser.Canonical(parser.Substitute(node, map[*parser.Node]float64{node.Operand: -2})))
+}
+
+// Тесты для текста шагов
+func TestStepText(t *testing.T) {
+	assert.Equal(t, locale.LangRussian, locale.Language("ru-RU,ru;q=0.9,en;q=0.8"))
+	assert.Equal(t, locale.LangEnglish, locale.Language("de-DE, en;q=0.5"))
+	assert.Equal(t, locale.LangEnglish, locale.Language(""))
+
+	assert.Equal(t, "Step 1: 10 + 2 = 12, the expression becomes 12 * 2 - 3",
+		locale.StepText(locale.LangEnglish, 1, "10 + 2", "12", "12 * 2 - 3"))
+	assert.Equal(t, "Шаг 3: 24 - 3 = 21 — это ответ",
+		locale.StepText(locale.LangRussian, 3, "24 - 3", "21", "21"))
+}
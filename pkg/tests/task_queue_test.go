@@ -0,0 +1,432 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xKARASb/Calculator/internal/orchestrator/repository"
+	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/precisions"
+	"github.com/xKARASb/Calculator/pkg/utils/priorities"
+	"github.com/xKARASb/Calculator/pkg/utils/retry"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты для объединения одинаковых задач в очереди
+func TestTaskQueueCoalesce(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	first := queue.Enqueue(2, 3, "+")
+	second := queue.Enqueue(2, 3, "+")
+	other := queue.Enqueue(3, 2, "+")
+
+	task, err := queue.Next("agent-1")
+	require.NoError(t, err)
+	assert.Equal(t, "+", task.Task.Operation)
+
+	// Пока задача выполняется, новые одинаковые задачи к ней присоединяются
+	third := queue.Enqueue(2, 3, "+")
+
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 5}))
+
+	for _, done := range []<-chan repository.TaskOutcome{first, second, third} {
+		outcome := <-done
+		assert.NoError(t, outcome.Err)
+		assert.Equal(t, float64(5), outcome.Result)
+	}
+
+	stats := queue.Stats()
+	assert.Equal(t, int64(2), stats.Submitted)
+	assert.Equal(t, int64(2), stats.Coalesced)
+	assert.Equal(t, 1, stats.Pending)
+	assert.Len(t, other, 0)
+}
+
+func TestTaskQueueMemo(t *testing.T) {
+	queue := repository.NewTaskQueue(time.Minute)
+
+	done := queue.Enqueue(6, 3, "/")
+	task, err := queue.Next("agent-1")
+	require.NoError(t, err)
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 2}))
+	assert.Equal(t, float64(2), (<-done).Result)
+
+	// Повторная задача берётся из памяти и не попадает в очередь
+	outcome := <-queue.Enqueue(6, 3, "/")
+	assert.Equal(t, float64(2), outcome.Result)
+
+	_, err = queue.Next("agent-1")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	stats := queue.Stats()
+	assert.Equal(t, int64(1), stats.MemoHits)
+	assert.Equal(t, int64(1), stats.MemoMisses)
+}
+
+func TestTaskQueueError(t *testing.T) {
+	queue := repository.NewTaskQueue(time.Minute)
+
+	done := queue.Enqueue(1, 0, "/")
+	task, err := queue.Next("agent-1")
+	require.NoError(t, err)
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Error: errors.CodeDivisionByZero}))
+	assert.ErrorIs(t, (<-done).Err, errors.ErrDivisionByZero)
+
+	// Ошибки не запоминаются
+	queue.Enqueue(1, 0, "/")
+	assert.Equal(t, 1, queue.Stats().Pending)
+
+	// Результат для неизвестной задачи отклоняется
+	assert.ErrorIs(t, queue.Complete(models.Result{ID: 100}), errors.ErrNotFound)
+}
+
+// Тесты для сведений о выполнении задачи
+func TestTaskQueueOutcomeAttribution(t *testing.T) {
+	queue := repository.NewTaskQueue(time.Minute)
+
+	first := queue.Enqueue(1, 2, "*")
+	second := queue.Enqueue(1, 2, "*")
+
+	task, err := queue.Next("host-1")
+	require.NoError(t, err)
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 2}))
+
+	outcome := <-first
+	assert.Equal(t, task.Task.ID, outcome.TaskID)
+	assert.Equal(t, "host-1", outcome.Agent)
+	assert.Equal(t, repository.SourceAgent, outcome.Source)
+	assert.False(t, outcome.StartedAt.Before(outcome.EnqueuedAt))
+	assert.False(t, outcome.FinishedAt.Before(outcome.StartedAt))
+
+	assert.Equal(t, repository.SourceCoalesced, (<-second).Source)
+	assert.Equal(t, repository.SourceMemo, (<-queue.Enqueue(1, 2, "*")).Source)
+}
+
+// Тесты для отзыва задач отменённых выражений
+func TestTaskQueueWithdraw(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	pending := queue.Enqueue(1, 1, "+")
+	queue.Withdraw(pending)
+
+	_, err := queue.Next("agent-1")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	// Задача остаётся, пока её ждёт хотя бы одно выражение
+	first := queue.Enqueue(2, 2, "+")
+	second := queue.Enqueue(2, 2, "+")
+	task, err := queue.Next("agent-1")
+	require.NoError(t, err)
+
+	queue.Withdraw(first)
+	assert.NoError(t, queue.Status(task.Task.ID))
+
+	queue.Withdraw(second)
+	assert.ErrorIs(t, queue.Status(task.Task.ID), errors.ErrTaskCancelled)
+	assert.ErrorIs(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 4}), errors.ErrTaskCancelled)
+	assert.ErrorIs(t, queue.Status(task.Task.ID), errors.ErrNotFound)
+
+	assert.Equal(t, int64(2), queue.Stats().Cancelled)
+}
+
+// Результат принимается только от агента, которому выдали задачу
+func TestTaskQueueForeignResult(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	done := queue.Enqueue(2, 3, "+")
+	task, err := queue.Next("agent-1")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, queue.CompleteBy("agent-2", models.Result{ID: task.Task.ID, Result: 6}), errors.ErrForbidden)
+	assert.ErrorIs(t, queue.CompleteBy("", models.Result{ID: task.Task.ID, Result: 6}), errors.ErrForbidden)
+
+	require.NoError(t, queue.CompleteBy("agent-1", models.Result{ID: task.Task.ID, Result: 5}))
+	assert.Equal(t, float64(5), (<-done).Result)
+}
+
+// Тесты для справедливого распределения задач между пользователями
+func TestTaskQueueFairness(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	busy := repository.TaskOwner{Expression: 1, User: 1, Priority: priorities.PriorityNormal}
+	for i := 0; i < 10; i++ {
+		queue.EnqueueFor(busy, float64(i), 1, "+")
+	}
+
+	other := repository.TaskOwner{Expression: 2, User: 2, Priority: priorities.PriorityNormal}
+	queue.EnqueueFor(other, 100, 1, "+")
+	assert.Equal(t, 2, queue.Position(other.Expression))
+	assert.Equal(t, 1, queue.Position(busy.Expression))
+
+	var order []float64
+	for i := 0; i < 3; i++ {
+		task, err := queue.Next("agent-1")
+		require.NoError(t, err)
+		order = append(order, task.Task.Arg1)
+	}
+	assert.Contains(t, order, float64(100))
+	assert.Equal(t, 0, queue.Position(other.Expression))
+}
+
+func TestTaskQueuePriority(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	low := repository.TaskOwner{Expression: 1, User: 1, Priority: priorities.PriorityLow}
+	high := repository.TaskOwner{Expression: 2, User: 1, Priority: priorities.PriorityHigh}
+	for i := 0; i < 10; i++ {
+		queue.EnqueueFor(low, float64(i), 1, "+")
+		queue.EnqueueFor(high, float64(i), 2, "+")
+	}
+
+	counts := map[float64]int{}
+	for i := 0; i < 10; i++ {
+		task, err := queue.Next("agent-1")
+		require.NoError(t, err)
+		counts[task.Task.Arg2]++
+	}
+	assert.Equal(t, 8, counts[2])
+	assert.Equal(t, 2, counts[1])
+
+	// Срочное выражение поднимает общую с ним задачу
+	queue = repository.NewTaskQueue(0)
+	for i := 0; i < 5; i++ {
+		queue.EnqueueFor(low, float64(i), 1, "+")
+	}
+	queue.EnqueueFor(high, 4, 1, "+")
+	assert.Equal(t, 2, queue.Position(high.Expression))
+
+	// Все позиции сразу совпадают с позицией каждого выражения
+	assert.Equal(t, map[int]int{low.Expression: 1, high.Expression: 2}, queue.Positions())
+}
+
+func TestPriorityClamp(t *testing.T) {
+	assert.Equal(t, priorities.PriorityNormal, priorities.Clamp(priorities.PriorityHigh, priorities.PriorityNormal))
+	assert.Equal(t, priorities.PriorityLow, priorities.Clamp(priorities.PriorityLow, priorities.PriorityNormal))
+	assert.False(t, priorities.Valid("urgent"))
+}
+
+// Задача, выданная без агента, не истекает, пока её не взяли
+func TestTaskQueueDispatchAssign(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	queue.SetRetryPolicy(retry.Policies{Default: retry.Policy{MaxAttempts: 2}}, time.Second)
+	queue.SetIDBase(1000)
+
+	done := queue.Enqueue(2, 3, "+")
+	task, err := queue.Dispatch()
+	require.NoError(t, err)
+	assert.Equal(t, 1001, task.Task.ID)
+
+	queue.Reap(time.Now().Add(time.Minute))
+	assert.Equal(t, int64(0), queue.Stats().Expired)
+
+	queue.Assign(task.Task.ID, "agent-1")
+	require.NoError(t, queue.Complete(models.Result{ID: task.Task.ID, Result: 5}))
+
+	outcome := <-done
+	assert.Equal(t, "agent-1", outcome.Agent)
+	assert.Equal(t, float64(5), outcome.Result)
+}
+
+// Задача, которую не удалось передать агентам, возвращается в очередь
+func TestTaskQueueUndispatch(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+
+	done := queue.Enqueue(2, 3, "+")
+	task, err := queue.Dispatch()
+	require.NoError(t, err)
+	assert.Equal(t, 1, queue.Stats().Running)
+
+	queue.Undispatch(task.Task.ID)
+	stats := queue.Stats()
+	assert.Equal(t, 1, stats.Pending)
+	assert.Zero(t, stats.Running)
+
+	again := nextTask(t, queue, "agent-1")
+	assert.Equal(t, task.Task.ID, again.Task.ID)
+	require.NoError(t, queue.CompleteBy("agent-1", models.Result{ID: task.Task.ID, Result: 5}))
+	assert.Equal(t, float64(5), (<-done).Result)
+}
+
+// Агент получает только те задачи, которые умеет выполнять
+func TestTaskQueueCapabilities(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	single := repository.TaskOwner{Expression: 1, Precision: precisions.PrecisionSingle}
+	double := repository.TaskOwner{Expression: 2}
+
+	queue.EnqueueFor(single, 1, 2, "*")
+	queue.EnqueueFor(double, 1, 2, "*")
+	queue.EnqueueFor(double, 1, 2, "+")
+
+	adder := models.Capabilities{Operations: []string{"+", "-"}}
+	task, err := queue.NextFor("adder", adder)
+	require.NoError(t, err)
+	assert.Equal(t, "+", task.Task.Operation)
+
+	_, err = queue.NextFor("adder", adder)
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	// Задачи с разной точностью не объединяются
+	waiting := queue.Waiting()
+	require.Len(t, waiting, 2)
+	assert.Equal(t, precisions.PrecisionSingle, waiting[0].Task.Precision)
+	assert.Equal(t, []int{1}, waiting[0].Expressions)
+
+	task, err = queue.NextFor("gpu", models.Capabilities{Precisions: []string{precisions.PrecisionSingle}})
+	require.NoError(t, err)
+	assert.Equal(t, precisions.PrecisionSingle, task.Task.Precision)
+
+	assert.False(t, models.Capabilities{Operations: []string{"+"}}.Supports(waiting[1].Task))
+	assert.True(t, models.Capabilities{}.Supports(waiting[1].Task))
+	assert.Len(t, queue.Waiting(), 1)
+}
+
+// Зависшая задача получает копию у другого агента, и побеждает первый ответ
+func TestTaskQueueSpeculation(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	queue.SetSpeculation(1, 0)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "slow")
+	time.Sleep(30 * time.Millisecond)
+
+	// Агент не получает копию собственной задачи
+	_, err := queue.Next("slow")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	backup := nextTask(t, queue, "fast")
+	assert.Equal(t, task.Task.ID, backup.Task.ID)
+
+	_, err = queue.Next("other")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	require.NoError(t, queue.CompleteBy("fast", models.Result{ID: task.Task.ID, Result: 5}))
+	outcome := <-done
+	assert.Equal(t, "fast", outcome.Agent)
+	assert.Equal(t, float64(5), outcome.Result)
+
+	// Проигравшая копия узнаёт, что её результат больше не нужен
+	assert.ErrorIs(t, queue.Status(task.Task.ID), errors.ErrTaskCancelled)
+	assert.ErrorIs(t, queue.CompleteBy("slow", models.Result{ID: task.Task.ID, Result: 5}), errors.ErrTaskCancelled)
+
+	stats := queue.Stats()
+	assert.Equal(t, int64(1), stats.Speculated)
+	assert.Equal(t, int64(1), stats.SpeculationWins)
+}
+
+// Сбой одной копии не перезапускает задачу, пока работает другая
+func TestTaskQueueSpeculationFailure(t *testing.T) {
+	queue := retryQueue(3)
+	queue.SetSpeculation(1, 0)
+
+	done := queue.Enqueue(2, 3, "+")
+	task := nextTask(t, queue, "slow")
+	time.Sleep(30 * time.Millisecond)
+	nextTask(t, queue, "fast")
+
+	require.NoError(t, queue.CompleteBy("fast", models.Result{ID: task.Task.ID, Error: errors.CodeAgentFailure}))
+	assert.Equal(t, int64(0), queue.Stats().Retried)
+
+	require.NoError(t, queue.CompleteBy("slow", models.Result{ID: task.Task.ID, Result: 5}))
+	outcome := <-done
+	assert.Equal(t, "slow", outcome.Agent)
+	assert.Equal(t, int64(0), queue.Stats().SpeculationWins)
+}
+
+// Проверяемая задача выполняется разными агентами и завершается при совпадении результатов
+func TestTaskQueueVerification(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	queue.SetVerification(1e-9, nil)
+
+	done := queue.EnqueueFor(repository.TaskOwner{Expression: 1, Verify: 2}, 2, 3, "+")
+	task := nextTask(t, queue, "first")
+	assert.Equal(t, 2, task.Task.Verify)
+
+	// Один агент не получает две копии задачи
+	_, err := queue.Next("first")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	second := nextTask(t, queue, "second")
+	assert.Equal(t, task.Task.ID, second.Task.ID)
+
+	_, err = queue.Next("third")
+	assert.ErrorIs(t, err, errors.ErrNotAvailable)
+
+	require.NoError(t, queue.CompleteBy("first", models.Result{ID: task.Task.ID, Result: 5}))
+	select {
+	case <-done:
+		t.Fatal("task finished before every copy answered")
+	default:
+	}
+
+	require.NoError(t, queue.CompleteBy("second", models.Result{ID: task.Task.ID, Result: 5 + 1e-12}))
+	outcome := <-done
+	require.NoError(t, outcome.Err)
+	assert.Equal(t, float64(5), outcome.Result)
+	assert.Equal(t, "first", outcome.Agent)
+	assert.Len(t, outcome.Attempts, 2)
+	assert.Equal(t, int64(1), queue.Stats().Verified)
+}
+
+// Расхождение результатов завершает задачу ошибкой и называет агентов вне большинства
+func TestTaskQueueVerificationDisagreement(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	reported := make(chan models.Disagreement, 1)
+	queue.SetVerification(1e-9, func(disagreement models.Disagreement) {
+		reported <- disagreement
+	})
+
+	done := queue.EnqueueFor(repository.TaskOwner{Expression: 7, Verify: 3}, 2, 3, "*")
+	task := nextTask(t, queue, "a")
+	nextTask(t, queue, "b")
+	nextTask(t, queue, "c")
+
+	require.NoError(t, queue.CompleteBy("a", models.Result{ID: task.Task.ID, Result: 6}))
+	require.NoError(t, queue.CompleteBy("b", models.Result{ID: task.Task.ID, Result: 7}))
+	require.NoError(t, queue.CompleteBy("c", models.Result{ID: task.Task.ID, Result: 6}))
+
+	outcome := <-done
+	require.ErrorIs(t, outcome.Err, errors.ErrVerificationFailed)
+	body := errors.NewBody(outcome.Err)
+	assert.Equal(t, errors.CodeVerification, body.Code)
+	assert.Equal(t, []errors.Conflict{
+		{Agent: "a", Result: 6},
+		{Agent: "b", Result: 7},
+		{Agent: "c", Result: 6},
+	}, body.Conflicts)
+
+	disagreement := <-reported
+	assert.Equal(t, []string{"b"}, disagreement.Outliers)
+	assert.Equal(t, []int{7}, disagreement.Expressions)
+	assert.Equal(t, int64(1), queue.Stats().Disagreements)
+}
+
+// Расхождение с бесконечным или NaN-ответом всё равно отдаётся клиенту
+func TestTaskQueueVerificationNonFinite(t *testing.T) {
+	queue := repository.NewTaskQueue(0)
+	queue.SetVerification(1e-9, nil)
+
+	done := queue.EnqueueFor(repository.TaskOwner{Expression: 8, Verify: 2}, 2, 3, "*")
+	task := nextTask(t, queue, "a")
+	nextTask(t, queue, "b")
+
+	require.NoError(t, queue.CompleteBy("a", models.Result{ID: task.Task.ID, Result: 6}))
+	require.NoError(t, queue.CompleteBy("b", models.Result{ID: task.Task.ID, Result: math.NaN()}))
+
+	outcome := <-done
+	require.ErrorIs(t, outcome.Err, errors.ErrVerificationFailed)
+	_, response := errors.NewResponse(outcome.Err)
+	data, err := json.Marshal(response)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"result":"NaN"`)
+
+	var decoded errors.Response
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Len(t, decoded.Error.Conflicts, 2)
+	assert.Equal(t, float64(6), decoded.Error.Conflicts[0].Result)
+	assert.True(t, math.IsNaN(decoded.Error.Conflicts[1].Result))
+}
@@ -47,6 +47,34 @@ func (m *MockCalculatorRepository) Calculate(expression string) (int, error) {
 	return 1, nil
 }
 
+func (m *MockCalculatorRepository) Submit(user models.User, request models.Request) (*models.Response, error) {
+	return &models.Response{ID: 1}, nil
+}
+
+func (m *MockCalculatorRepository) Schedule(user models.User, request models.Request) (*models.Schedule, error) {
+	return &models.Schedule{Schedule: models.ScheduleData{ID: 1, UserID: user.ID, Request: request}}, nil
+}
+
+func (m *MockCalculatorRepository) ListSchedules(user models.User) (*models.Schedules, error) {
+	return &models.Schedules{}, nil
+}
+
+func (m *MockCalculatorRepository) GetSchedule(user models.User, id int) (*models.Schedule, error) {
+	return &models.Schedule{Schedule: models.ScheduleData{ID: id}}, nil
+}
+
+func (m *MockCalculatorRepository) DeleteSchedule(user models.User, id int) (*models.Schedule, error) {
+	return &models.Schedule{Schedule: models.ScheduleData{ID: id}}, nil
+}
+
+func (m *MockCalculatorRepository) Validate(user models.User, request models.Request) (*models.Validation, error) {
+	return &models.Validation{Valid: true}, nil
+}
+
+func (m *MockCalculatorRepository) Convert(user models.User, request models.Request) (*models.Conversion, error) {
+	return &models.Conversion{Infix: request.Expression}, nil
+}
+
 func (m *MockCalculatorRepository) GetAllExpressions() ([]models.Expression, error) {
 	return []models.Expression{}, nil
 }
@@ -61,6 +89,26 @@ func (m *MockCalculatorRepository) GetExpressionByID(id int) (*models.Expression
 	}, nil
 }
 
+func (m *MockCalculatorRepository) GetSteps(id int) (*models.Steps, error) {
+	return &models.Steps{ID: id}, nil
+}
+
+func (m *MockCalculatorRepository) GetTrace(id int) (*models.Trace, error) {
+	return &models.Trace{ID: id}, nil
+}
+
+func (m *MockCalculatorRepository) Cancel(user models.User, id int) (*models.Expression, error) {
+	return &models.Expression{Expression: models.ExpressionData{ID: id, Status: statuses.StatusCancelled}}, nil
+}
+
+func (m *MockCalculatorRepository) ResolveExpressionID(uid string) (int, error) {
+	return 0, nil
+}
+
+func (m *MockCalculatorRepository) TaskStatus(taskID int) error {
+	return nil
+}
+
 func (m *MockCalculatorRepository) GetCurrentTask() (*models.Task, error) {
 	return &models.Task{
 		Task: models.TaskData{
@@ -69,6 +117,26 @@ func (m *MockCalculatorRepository) GetCurrentTask() (*models.Task, error) {
 	}, nil
 }
 
+func (m *MockCalculatorRepository) NextTask(agentID string) (*models.Task, error) {
+	return m.GetCurrentTask()
+}
+
+func (m *MockCalculatorRepository) SetTaskResult(agentID string, result models.Result) error {
+	return nil
+}
+
+func (m *MockCalculatorRepository) NextTasks(agentID string, limit int) (*models.Tasks, error) {
+	return &models.Tasks{Tasks: []models.TaskData{}}, nil
+}
+
+func (m *MockCalculatorRepository) SetTaskResults(agentID string, results []models.Result) (*models.ResultStatuses, error) {
+	return &models.ResultStatuses{}, nil
+}
+
+func (m *MockCalculatorRepository) Metrics() (*models.Metrics, error) {
+	return &models.Metrics{}, nil
+}
+
 func (m *MockCalculatorRepository) GetResult() (*models.Result, error) {
 	return &models.Result{
 		ID:     1,
@@ -84,7 +152,51 @@ func (m *MockCalculatorRepository) Register(login, password string) error {
 	return nil
 }
 
-func (m *MockCalculatorRepository) Login(login, password string) error {
+func (m *MockCalculatorRepository) Login(login, password string) (*models.User, error) {
+	return &models.User{ID: 1, Login: login}, nil
+}
+
+func (m *MockCalculatorRepository) SetUserRole(login, role string) error {
+	return nil
+}
+
+func (m *MockCalculatorRepository) GetUserLimits(userID int) (*models.UserLimits, error) {
+	return &models.UserLimits{UserID: userID}, nil
+}
+
+func (m *MockCalculatorRepository) SetUserLimits(limits models.UserLimits) error {
+	return nil
+}
+
+func (m *MockCalculatorRepository) DeleteUserLimits(userID int) error {
+	return nil
+}
+
+func (m *MockCalculatorRepository) ListDeadLetters() (*models.DeadLetters, error) {
+	return &models.DeadLetters{}, nil
+}
+
+func (m *MockCalculatorRepository) GetDeadLetter(id int) (*models.DeadLetter, error) {
+	return &models.DeadLetter{ID: id}, nil
+}
+
+func (m *MockCalculatorRepository) RequeueDeadLetter(id int) (*models.DeadLetter, error) {
+	return &models.DeadLetter{ID: id}, nil
+}
+
+func (m *MockCalculatorRepository) DiscardDeadLetter(id int) (*models.DeadLetter, error) {
+	return &models.DeadLetter{ID: id}, nil
+}
+
+func (m *MockCalculatorRepository) ListAgents() (*models.Agents, error) {
+	return &models.Agents{}, nil
+}
+
+func (m *MockCalculatorRepository) ReleaseAgent(agentID string) (*models.AgentInfo, error) {
+	return &models.AgentInfo{ID: agentID}, nil
+}
+
+func (m *MockCalculatorRepository) RegisterAgent(agentID string, capabilities models.Capabilities) error {
 	return nil
 }
 
@@ -175,7 +287,7 @@ func setupIntegrationTestServer(t *testing.T) (*testServer, func()) {
 	}
 
 	ctx := context.Background()
-	repo := repository.NewCalculatorRepository(ctx, db, redisClient)
+	repo := repository.NewCalculatorRepository(ctx, repository.CalculatorRepositoryConfig{}, db, redisClient)
 	srv := service.NewCalculatorService(repo)
 
 	e := echo.New()
@@ -203,7 +315,7 @@ func setupIntegrationTestServer(t *testing.T) (*testServer, func()) {
 	var agentStopped bool
 	agentStop := make(chan struct{})
 	go func() {
-		a := agent.NewAgent(1)
+		a := agent.NewAgent(1, "localhost")
 		go func() {
 			<-agentStop
 			agentStopped = true
@@ -0,0 +1,24 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/xKARASb/Calculator/pkg/utils/uid"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Тесты для непрозрачных идентификаторов
+func TestUID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := uid.New()
+		assert.True(t, uid.Valid(id), id)
+		assert.Equal(t, byte('4'), id[14])
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+
+	assert.False(t, uid.Valid("42"))
+	assert.False(t, uid.Valid("6ba7b810-9dad-11d1-80b4-00c04fd430cZ"))
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/xKARASb/Calculator/internal/orchestrator/service"
 	"github.com/xKARASb/Calculator/pkg/models"
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+	"github.com/xKARASb/Calculator/pkg/utils/jwt"
+	"github.com/xKARASb/Calculator/pkg/utils/parser"
+	"github.com/xKARASb/Calculator/pkg/utils/roles"
 	"github.com/xKARASb/Calculator/pkg/utils/statuses"
 
 	"github.com/labstack/echo/v4"
@@ -70,11 +75,19 @@ func TestUnitCalculatorService_GetResult(t *testing.T) {
 	assert.Equal(t, float64(4), result.Result)
 }
 
+// Запрос к API от имени авторизованного пользователя
+func newAPIRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+jwt.NewAccessToken(1, roles.RoleUser, "secret"))
+	return req
+}
+
 // Тесты для API калькулятора
 func TestUnitCalculatorAPI_Calculate(t *testing.T) {
 	// Настройка
 	e := echo.New()
-	req := httptest.NewRequest(http.MethodGet, "/calculate?expression=2+2", nil)
+	req := newAPIRequest(http.MethodPost, "/api/v1/calculate", `{"expression":"2+2"}`)
 	rec := httptest.NewRecorder()
 
 	// Создаем сервис с мок-репозиторием
@@ -94,7 +107,7 @@ func TestUnitCalculatorAPI_Calculate(t *testing.T) {
 func TestUnitCalculatorAPI_GetExpression(t *testing.T) {
 	// Настройка
 	e := echo.New()
-	req := httptest.NewRequest(http.MethodGet, "/expressions/1", nil)
+	req := newAPIRequest(http.MethodGet, "/api/v1/expressions/1", "")
 	rec := httptest.NewRecorder()
 
 	// Создаем сервис с мок-репозиторием
@@ -123,7 +136,7 @@ func TestUnitCalculatorAPI_GetExpression(t *testing.T) {
 func TestUnitCalculatorAPI_GetAllExpressions(t *testing.T) {
 	// Настройка
 	e := echo.New()
-	req := httptest.NewRequest(http.MethodGet, "/expressions", nil)
+	req := newAPIRequest(http.MethodGet, "/api/v1/expressions", "")
 	rec := httptest.NewRecorder()
 
 	// Создаем сервис с мок-репозиторием
@@ -167,7 +180,7 @@ func TestUnitCalculatorAPI_InvalidExpression(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Настройка
 			e := echo.New()
-			req := httptest.NewRequest(http.MethodGet, "/calculate?expression="+tt.expression, nil)
+			req := newAPIRequest(http.MethodPost, "/api/v1/calculate", `{"expression":"`+tt.expression+`"}`)
 			rec := httptest.NewRecorder()
 
 			// Создаем сервис с мок-репозиторием, который будет возвращать ошибку
@@ -180,8 +193,12 @@ func TestUnitCalculatorAPI_InvalidExpression(t *testing.T) {
 			// Выполняем запрос
 			e.ServeHTTP(rec, req)
 
-			// Проверяем, что вернулся статус BadRequest
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			// Проверяем, что вернулся статус UnprocessableEntity с кодом ошибки
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+			var response errors.Response
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.NotEmpty(t, response.Error.Code)
 		})
 	}
 }
@@ -198,5 +215,11 @@ func NewErrorMockCalculatorRepository() *ErrorMockCalculatorRepository {
 }
 
 func (m *ErrorMockCalculatorRepository) Calculate(expression string) (int, error) {
-	return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid expression")
+	_, err := parser.Parse(expression)
+	return 0, err
+}
+
+func (m *ErrorMockCalculatorRepository) Submit(user models.User, request models.Request) (*models.Response, error) {
+	_, err := parser.Parse(request.Expression)
+	return nil, err
 }
@@ -0,0 +1,165 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron spec of five fields: minute, hour, day of month,
+// month and day of week (0 or 7 is Sunday). A field is *, a number, a range
+// a-b, a step like */15, 1-30/2 or 5/10, or a comma separated list of these.
+type Schedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// Cron runs on days that match either day field when both are
+	// restricted, and on days that match both otherwise.
+	anyDay bool
+}
+
+type bounds struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// horizon bounds the search for the next fire time of specs like "0 0 30 2 *"
+// that never fire.
+const horizon = 5 * 366 * 24 * time.Hour
+
+func Parse(spec string) (*Schedule, error) {
+	value := strings.TrimSpace(spec)
+	if macro, ok := macros[value]; ok {
+		value = macro
+	}
+
+	parts := strings.Fields(value)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron spec %q: want %d fields", spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDay: strings.HasPrefix(parts[2], "*") || strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(value string, b bounds) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(value, ",") {
+		lo, hi, step := b.min, b.max, 1
+
+		expr := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("%s: invalid step in %q", b.name, part)
+			}
+			expr = part[:i]
+		}
+
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			from, to, _ := strings.Cut(expr, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(from)
+			hi, err2 = strconv.Atoi(to)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("%s: invalid range %q", b.name, part)
+			}
+		default:
+			var err error
+			if lo, err = strconv.Atoi(expr); err != nil {
+				return 0, fmt.Errorf("%s: invalid value %q", b.name, part)
+			}
+			// "5/10" starts at 5 and runs to the end of the field.
+			hi = lo
+			if strings.Contains(part, "/") {
+				hi = b.max
+			}
+		}
+
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, fmt.Errorf("%s: %q is out of range %d-%d", b.name, part, b.min, b.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first fire time after t in the location of t, or the zero
+// time when the spec never fires.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.Add(horizon)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,285 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	CodeInvalidExpression = "INVALID_EXPRESSION"
+	CodeEmptyExpression   = "EMPTY_EXPRESSION"
+	CodeUnexpectedToken   = "UNEXPECTED_TOKEN"
+	CodeUnexpectedEnd     = "UNEXPECTED_END"
+	CodeInvalidNumber     = "INVALID_NUMBER"
+	CodeInvalidOperation  = "INVALID_OPERATION"
+	CodeDivisionByZero    = "DIVISION_BY_ZERO"
+	CodeOverflow          = "OVERFLOW"
+	CodeNaN               = "NAN"
+	CodeUnknownOperation  = "UNKNOWN_OPERATION"
+	CodeUnbalancedParen   = "UNBALANCED_PAREN"
+	CodeTooLong           = "EXPRESSION_TOO_LONG"
+	CodeTooDeep           = "EXPRESSION_TOO_DEEP"
+	CodeTooManyTasks      = "TOO_MANY_TASKS"
+	CodeEstimateTooLong   = "ESTIMATE_TOO_LONG"
+	CodeTaskQueueFull     = "TASK_QUEUE_FULL"
+	CodeNotAvailable      = "NOT_AVAILABLE"
+	CodeNotFound          = "NOT_FOUND"
+	CodeInvalidRequest    = "INVALID_REQUEST"
+	CodeInvalidFormat     = "INVALID_FORMAT"
+	CodeInvalidNotation   = "INVALID_NOTATION"
+	CodeInvalidRender     = "INVALID_RENDER"
+	CodeAlreadyFinished   = "ALREADY_FINISHED"
+	CodeTaskCancelled     = "TASK_CANCELLED"
+	CodeDeadLetterGone    = "DEAD_LETTER_GONE"
+	CodeDeadlineExceeded  = "DEADLINE_EXCEEDED"
+	CodeLeaseExpired      = "LEASE_EXPIRED"
+	CodeAgentFailure      = "AGENT_FAILURE"
+	CodeNoScheduler       = "SCHEDULER_UNAVAILABLE"
+	CodeNotRegistered     = "AGENT_NOT_REGISTERED"
+	CodeQuarantined       = "AGENT_QUARANTINED"
+	CodeVerification      = "VERIFICATION_FAILED"
+	CodeUserExists        = "USER_ALREADY_EXISTS"
+	CodeInvalidLogin      = "INVALID_CREDENTIALS"
+	CodeUnauthorized      = "UNAUTHORIZED"
+	CodeInvalidToken      = "INVALID_TOKEN"
+	CodeForbidden         = "FORBIDDEN"
+	CodeInternal          = "INTERNAL"
+)
+
+type kind struct {
+	err    error
+	code   string
+	status int
+}
+
+// kinds is checked in order, so an error wrapping several sentinels always
+// gets the code of the first one listed.
+var kinds = []kind{
+	{ErrEmptyExpression, CodeEmptyExpression, http.StatusUnprocessableEntity},
+	{ErrUnexpectedToken, CodeUnexpectedToken, http.StatusUnprocessableEntity},
+	{ErrUnexpectedEnd, CodeUnexpectedEnd, http.StatusUnprocessableEntity},
+	{ErrInvalidNumber, CodeInvalidNumber, http.StatusUnprocessableEntity},
+	{ErrInvalidOperation, CodeInvalidOperation, http.StatusUnprocessableEntity},
+	{ErrDivisionByZero, CodeDivisionByZero, http.StatusUnprocessableEntity},
+	{ErrOverflow, CodeOverflow, http.StatusUnprocessableEntity},
+	{ErrNaN, CodeNaN, http.StatusUnprocessableEntity},
+	{ErrUnknownOperation, CodeUnknownOperation, http.StatusUnprocessableEntity},
+	{ErrMismatchedParentheses, CodeUnbalancedParen, http.StatusUnprocessableEntity},
+	{ErrInvalidExpression, CodeInvalidExpression, http.StatusUnprocessableEntity},
+	{ErrExpressionTooLong, CodeTooLong, http.StatusRequestEntityTooLarge},
+	{ErrExpressionTooDeep, CodeTooDeep, http.StatusUnprocessableEntity},
+	{ErrTooManyTasks, CodeTooManyTasks, http.StatusUnprocessableEntity},
+	{ErrEstimateTooLong, CodeEstimateTooLong, http.StatusUnprocessableEntity},
+	{ErrQueueFull, CodeTaskQueueFull, http.StatusTooManyRequests},
+	{ErrNotAvailable, CodeNotAvailable, http.StatusNotFound},
+	{ErrNotFound, CodeNotFound, http.StatusNotFound},
+	{ErrInvalidRequest, CodeInvalidRequest, http.StatusBadRequest},
+	{ErrInvalidFormat, CodeInvalidFormat, http.StatusBadRequest},
+	{ErrInvalidNotation, CodeInvalidNotation, http.StatusBadRequest},
+	{ErrInvalidRender, CodeInvalidRender, http.StatusBadRequest},
+	{ErrExpressionFinished, CodeAlreadyFinished, http.StatusConflict},
+	{ErrTaskCancelled, CodeTaskCancelled, http.StatusGone},
+	{ErrDeadLetterGone, CodeDeadLetterGone, http.StatusGone},
+	{ErrDeadlineExceeded, CodeDeadlineExceeded, http.StatusGatewayTimeout},
+	{ErrLeaseExpired, CodeLeaseExpired, http.StatusGatewayTimeout},
+	{ErrAgentFailure, CodeAgentFailure, http.StatusBadGateway},
+	{ErrNoScheduler, CodeNoScheduler, http.StatusServiceUnavailable},
+	{ErrAgentNotRegistered, CodeNotRegistered, http.StatusConflict},
+	{ErrAgentQuarantined, CodeQuarantined, http.StatusForbidden},
+	{ErrVerificationFailed, CodeVerification, http.StatusBadGateway},
+	{ErrUserAlreadyExists, CodeUserExists, http.StatusConflict},
+	{ErrInvalidCredentials, CodeInvalidLogin, http.StatusUnauthorized},
+	{ErrUnauthorized, CodeUnauthorized, http.StatusUnauthorized},
+	{ErrInvalidToken, CodeInvalidToken, http.StatusUnauthorized},
+	{ErrForbidden, CodeForbidden, http.StatusForbidden},
+}
+
+type ExpressionError struct {
+	Err    error
+	Offset int
+	Token  string
+}
+
+func NewExpressionError(err error, offset int, token string) *ExpressionError {
+	return &ExpressionError{Err: err, Offset: offset, Token: token}
+}
+
+func (e *ExpressionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExpressionError) Unwrap() error {
+	return e.Err
+}
+
+// Conflict is the answer one agent gave to a task that several agents ran.
+type Conflict struct {
+	Agent  string  `json:"agent"`
+	Result float64 `json:"result"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// A non-finite answer travels as "+Inf", "-Inf" or "NaN", as results do in
+// pkg/models, so that the conflict can still be reported.
+func (c Conflict) MarshalJSON() ([]byte, error) {
+	type alias Conflict
+	var result interface{} = c.Result
+	if math.IsInf(c.Result, 0) || math.IsNaN(c.Result) {
+		result = strconv.FormatFloat(c.Result, 'g', -1, 64)
+	}
+	return json.Marshal(struct {
+		alias
+		Result interface{} `json:"result"`
+	}{alias(c), result})
+}
+
+func (c *Conflict) UnmarshalJSON(data []byte) error {
+	type alias Conflict
+	aux := struct {
+		*alias
+		Result json.RawMessage `json:"result"`
+	}{alias: (*alias)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.Result) == 0 || string(aux.Result) == "null" {
+		c.Result = 0
+		return nil
+	}
+
+	if aux.Result[0] == '"' {
+		var text string
+		if err := json.Unmarshal(aux.Result, &text); err != nil {
+			return err
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		c.Result = value
+		return err
+	}
+	return json.Unmarshal(aux.Result, &c.Result)
+}
+
+// VerificationError carries the answers of agents that did not agree.
+type VerificationError struct {
+	Conflicts []Conflict
+}
+
+func (e *VerificationError) Error() string {
+	return ErrVerificationFailed.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return ErrVerificationFailed
+}
+
+// RetryError asks the client to repeat the request after the given delay.
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the delay a RetryError asks for, or zero.
+func RetryAfter(err error) time.Duration {
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return retryErr.After
+	}
+	return 0
+}
+
+type Body struct {
+	Code      string     `json:"code"`
+	Message   string     `json:"message"`
+	Offset    *int       `json:"offset,omitempty"`
+	Token     string     `json:"token,omitempty"`
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+}
+
+type Response struct {
+	Error Body `json:"error"`
+}
+
+func Code(err error) string {
+	code, _ := classify(err)
+	return code
+}
+
+func Status(err error) int {
+	_, status := classify(err)
+	return status
+}
+
+func NewBody(err error) *Body {
+	body := &Body{Code: Code(err), Message: err.Error()}
+
+	var exprErr *ExpressionError
+	if errors.As(err, &exprErr) {
+		offset := exprErr.Offset
+		body.Offset = &offset
+		body.Token = exprErr.Token
+	}
+
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) {
+		body.Conflicts = verifyErr.Conflicts
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		if message, ok := httpErr.Message.(string); ok {
+			body.Message = message
+		}
+	}
+
+	return body
+}
+
+func NewResponse(err error) (int, Response) {
+	return Status(err), Response{Error: *NewBody(err)}
+}
+
+func classify(err error) (string, int) {
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.code, k.status
+		}
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return strings.ToUpper(strings.ReplaceAll(http.StatusText(httpErr.Code), " ", "_")), httpErr.Code
+	}
+
+	return CodeInternal, http.StatusInternalServerError
+}
+
+// Transient reports whether a task failure may go away when the task is run
+// again. Errors of the arithmetic itself, like division by zero, never do.
+func Transient(err error) bool {
+	return errors.Is(err, ErrLeaseExpired) || errors.Is(err, ErrAgentFailure)
+}
+
+func FromCode(code string) error {
+	for _, k := range kinds {
+		if k.code == code {
+			return k.err
+		}
+	}
+	return errors.New(code)
+}
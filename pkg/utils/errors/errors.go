@@ -4,14 +4,40 @@ import "errors"
 
 var (
 	ErrInvalidExpression     = errors.New("Invalid expression")
+	ErrEmptyExpression       = errors.New("Empty expression")
+	ErrUnexpectedToken       = errors.New("Unexpected token")
+	ErrUnexpectedEnd         = errors.New("Unexpected end of expression")
+	ErrInvalidNumber         = errors.New("Invalid number")
 	ErrInvalidOperation      = errors.New("Invalid operation")
 	ErrDivisionByZero        = errors.New("Division by zero")
+	ErrOverflow              = errors.New("Numeric overflow")
+	ErrNaN                   = errors.New("Result is not a number")
+	ErrExpressionTooLong     = errors.New("Expression is too long")
+	ErrExpressionTooDeep     = errors.New("Expression is nested too deeply")
+	ErrTooManyTasks          = errors.New("Expression has too many operations")
+	ErrEstimateTooLong       = errors.New("Expression would take too long")
 	ErrUnknownOperation      = errors.New("Unknown operation")
-	ErrTaskChanIsFull        = errors.New("Task channel is full")
+	ErrQueueFull             = errors.New("Task queue is full")
 	ErrNotAvailable          = errors.New("No available")
 	ErrMismatchedParentheses = errors.New("Mismatched parentheses")
 	ErrNotFound              = errors.New("Not found")
+	ErrInvalidRequest        = errors.New("Invalid request")
+	ErrInvalidFormat         = errors.New("Invalid format options")
+	ErrInvalidNotation       = errors.New("Unknown notation")
+	ErrInvalidRender         = errors.New("Unknown render format")
+	ErrExpressionFinished    = errors.New("Expression has already finished")
+	ErrTaskCancelled         = errors.New("Task was cancelled")
+	ErrDeadLetterGone        = errors.New("No expression waits for the task anymore")
+	ErrDeadlineExceeded      = errors.New("Expression deadline exceeded")
+	ErrLeaseExpired          = errors.New("Task lease expired")
+	ErrAgentFailure          = errors.New("Agent failed to run the task")
+	ErrNoScheduler           = errors.New("Task scheduler is not available")
+	ErrAgentNotRegistered    = errors.New("Agent is not registered")
+	ErrAgentQuarantined      = errors.New("Agent is quarantined")
+	ErrVerificationFailed    = errors.New("Agents returned different results")
 	ErrUserAlreadyExists     = errors.New("User already exists")
 	ErrInvalidCredentials    = errors.New("Invalid login or password")
+	ErrUnauthorized          = errors.New("Authorization token is missing")
 	ErrInvalidToken          = errors.New("Invalid token")
+	ErrForbidden             = errors.New("Forbidden")
 )
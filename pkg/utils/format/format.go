@@ -0,0 +1,228 @@
+package format
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	FormatFixed       = "fixed"
+	FormatScientific  = "scientific"
+	FormatEngineering = "engineering"
+	FormatFraction    = "fraction"
+
+	RoundingHalfEven = "half_even"
+	RoundingHalfUp   = "half_up"
+	RoundingTruncate = "truncate"
+
+	maxRound            = 17
+	defaultMaxFraction  = 6
+	defaultFractionBase = 10
+)
+
+type Options struct {
+	Round    *int   `json:"round,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Rounding string `json:"rounding,omitempty"`
+}
+
+func (o Options) IsZero() bool {
+	return o.Round == nil && o.Format == "" && o.Rounding == ""
+}
+
+// Merge returns o with every field set in override replaced.
+func (o Options) Merge(override Options) Options {
+	if override.Round != nil {
+		o.Round = override.Round
+	}
+	if override.Format != "" {
+		o.Format = override.Format
+	}
+	if override.Rounding != "" {
+		o.Rounding = override.Rounding
+	}
+	return o
+}
+
+func Validate(o Options) error {
+	if o.Round != nil && (*o.Round < 0 || *o.Round > maxRound) {
+		return fmt.Errorf("%w: round must be between 0 and %d", errors.ErrInvalidFormat, maxRound)
+	}
+
+	switch o.Format {
+	case "", FormatFixed, FormatScientific, FormatEngineering, FormatFraction:
+	default:
+		return fmt.Errorf("%w: unknown format %q", errors.ErrInvalidFormat, o.Format)
+	}
+
+	switch o.Rounding {
+	case "", RoundingHalfEven, RoundingHalfUp, RoundingTruncate:
+	default:
+		return fmt.Errorf("%w: unknown rounding mode %q", errors.ErrInvalidFormat, o.Rounding)
+	}
+
+	return nil
+}
+
+func Format(value float64, o Options) string {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+
+	mode := o.Rounding
+	if mode == "" {
+		mode = RoundingHalfEven
+	}
+
+	// Round from the shortest decimal form, so 2.675 is treated as written
+	// and not as its binary approximation 2.67499999...
+	shortest := strconv.FormatFloat(value, 'e', -1, 64)
+	exact, _ := new(big.Rat).SetString(shortest)
+	digits, exponent := splitExponent(shortest)
+
+	switch o.Format {
+	case FormatScientific:
+		return formatExponent(exact, exponent, 1, digits, o.Round, mode)
+	case FormatEngineering:
+		return formatExponent(exact, exponent, 3, digits, o.Round, mode)
+	case FormatFraction:
+		return formatFraction(exact, o.Round)
+	default:
+		places := max(0, digits-exponent)
+		if o.Round != nil {
+			places = *o.Round
+		}
+		return roundRat(exact, places, mode).FloatString(places)
+	}
+}
+
+func splitExponent(shortest string) (int, int) {
+	mantissa, exp, _ := strings.Cut(shortest, "e")
+	exponent, _ := strconv.Atoi(exp)
+
+	digits := 0
+	if _, fraction, ok := strings.Cut(mantissa, "."); ok {
+		digits = len(fraction)
+	}
+	return digits, exponent
+}
+
+func formatExponent(exact *big.Rat, exponent, step, digits int, round *int, mode string) string {
+	if exact.Sign() == 0 {
+		exponent = 0
+	}
+
+	shift := floorDiv(exponent, step) * step
+	places := max(0, digits-(exponent-shift))
+	if round != nil {
+		places = *round
+	}
+
+	mantissa := roundRat(new(big.Rat).Quo(exact, pow10(shift)), places, mode)
+
+	limit := pow10(step)
+	if new(big.Rat).Abs(mantissa).Cmp(limit) >= 0 {
+		shift += step
+		mantissa = roundRat(new(big.Rat).Quo(mantissa, limit), places, mode)
+	}
+
+	return fmt.Sprintf("%se%+03d", mantissa.FloatString(places), shift)
+}
+
+func formatFraction(exact *big.Rat, round *int) string {
+	precision := defaultMaxFraction
+	if round != nil {
+		precision = *round
+	}
+	maxDenominator := new(big.Int).Exp(big.NewInt(defaultFractionBase), big.NewInt(int64(precision)), nil)
+
+	fraction := approximate(exact, maxDenominator)
+	if fraction.IsInt() {
+		return fraction.Num().String()
+	}
+	return fraction.String()
+}
+
+// approximate finds the closest fraction with a bounded denominator using the
+// continued fraction expansion of value.
+func approximate(value *big.Rat, maxDenominator *big.Int) *big.Rat {
+	if value.Denom().Cmp(maxDenominator) <= 0 {
+		return new(big.Rat).Set(value)
+	}
+
+	p0, q0 := big.NewInt(0), big.NewInt(1)
+	p1, q1 := big.NewInt(1), big.NewInt(0)
+	num, den := new(big.Int).Set(value.Num()), new(big.Int).Set(value.Denom())
+
+	for den.Sign() != 0 {
+		a := new(big.Int)
+		rem := new(big.Int)
+		a.DivMod(num, den, rem)
+
+		q2 := new(big.Int).Add(q0, new(big.Int).Mul(a, q1))
+		if q2.Cmp(maxDenominator) > 0 {
+			break
+		}
+
+		p0, p1 = p1, new(big.Int).Add(p0, new(big.Int).Mul(a, p1))
+		q0, q1 = q1, q2
+		num, den = den, rem
+	}
+
+	// Semiconvergent with the largest denominator still in bounds.
+	k := new(big.Int).Quo(new(big.Int).Sub(maxDenominator, q0), q1)
+	bound := new(big.Rat).SetFrac(new(big.Int).Add(p0, new(big.Int).Mul(k, p1)), new(big.Int).Add(q0, new(big.Int).Mul(k, q1)))
+	convergent := new(big.Rat).SetFrac(p1, q1)
+
+	boundDiff := new(big.Rat).Abs(new(big.Rat).Sub(bound, value))
+	convergentDiff := new(big.Rat).Abs(new(big.Rat).Sub(convergent, value))
+	if boundDiff.Cmp(convergentDiff) < 0 {
+		return bound
+	}
+	return convergent
+}
+
+func roundRat(value *big.Rat, places int, mode string) *big.Rat {
+	scale := pow10(places)
+	scaled := new(big.Rat).Mul(new(big.Rat).Abs(value), scale)
+
+	quotient, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	half := new(big.Int).Mul(remainder, big.NewInt(2)).Cmp(scaled.Denom())
+
+	switch mode {
+	case RoundingHalfUp:
+		if half >= 0 {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	case RoundingHalfEven:
+		if half > 0 || (half == 0 && quotient.Bit(0) == 1) {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+
+	return new(big.Rat).Quo(new(big.Rat).SetInt(quotient), scale)
+}
+
+func pow10(exponent int) *big.Rat {
+	if exponent < 0 {
+		return new(big.Rat).Inv(pow10(-exponent))
+	}
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -3,10 +3,11 @@ package jwt
 import (
 	"fmt"
 	"math/rand"
-	"net/http"
 	"strings"
 	"time"
 
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+
 	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/labstack/echo/v4"
@@ -16,17 +17,17 @@ func JWTAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		authHeader := c.Request().Header.Get("Authorization")
 		if authHeader == "" {
-			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "authorization token is missing"})
+			return c.JSON(errors.NewResponse(errors.ErrUnauthorized))
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token format"})
+			return c.JSON(errors.NewResponse(fmt.Errorf("%w: invalid token format", errors.ErrInvalidToken)))
 		}
 
 		claims, err := ValidateToken(parts[1], "secret")
 		if err != nil {
-			return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token"})
+			return c.JSON(errors.NewResponse(errors.ErrInvalidToken))
 		}
 
 		c.Set("user", claims)
@@ -34,11 +35,35 @@ func JWTAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func NewAccessToken(id int64, secret string) string {
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, userRole := Claims(c); userRole != role {
+				return c.JSON(errors.NewResponse(errors.ErrForbidden))
+			}
+			return next(c)
+		}
+	}
+}
+
+func Claims(c echo.Context) (int, string) {
+	claims, ok := c.Get("user").(*jwt.MapClaims)
+	if !ok {
+		return 0, ""
+	}
+
+	id, _ := (*claims)["sub"].(float64)
+	role, _ := (*claims)["role"].(string)
+
+	return int(id), role
+}
+
+func NewAccessToken(id int64, role string, secret string) string {
 	token := jwt.New(jwt.SigningMethodHS512)
 	token.Claims = jwt.MapClaims{
-		"sub": id,
-		"exp": time.Now().Add(time.Minute * 30).Unix(),
+		"sub":  id,
+		"role": role,
+		"exp":  time.Now().Add(time.Minute * 30).Unix(),
 	}
 	tokenString, _ := token.SignedString([]byte(secret))
 	return tokenString
@@ -0,0 +1,52 @@
+package locale
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	LangRussian = "ru"
+	LangEnglish = "en"
+)
+
+type messages struct {
+	step     string
+	lastStep string
+}
+
+var catalog = map[string]messages{
+	LangRussian: {
+		step:     "Шаг %d: %s = %s, выражение принимает вид %s",
+		lastStep: "Шаг %d: %s = %s — это ответ",
+	},
+	LangEnglish: {
+		step:     "Step %d: %s = %s, the expression becomes %s",
+		lastStep: "Step %d: %s = %s, which is the result",
+	},
+}
+
+// Language picks the first supported language from an Accept-Language header
+// and falls back to English.
+func Language(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := catalog[lang]; ok {
+			return lang
+		}
+	}
+	return LangEnglish
+}
+
+func StepText(lang string, index int, expression, value, remaining string) string {
+	m, ok := catalog[lang]
+	if !ok {
+		m = catalog[LangEnglish]
+	}
+
+	if remaining == value {
+		return fmt.Sprintf(m.lastStep, index, expression, value)
+	}
+	return fmt.Sprintf(m.step, index, expression, value, remaining)
+}
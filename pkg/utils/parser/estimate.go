@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"sort"
+
+	"github.com/xKARASb/Calculator/pkg/utils/timings"
+)
+
+type Stats struct {
+	Operations     map[string]int
+	Tasks          int
+	Depth          int
+	CriticalPath   int
+	CriticalPathMS int
+}
+
+type estimateTask struct {
+	cost      int
+	rank      int
+	deps      int
+	parent    *estimateTask
+	finishing int
+}
+
+func Analyze(node *Node) Stats {
+	stats := Stats{Operations: make(map[string]int)}
+	stats.Depth = depth(node)
+	stats.CriticalPath, stats.CriticalPathMS = criticalPath(node)
+
+	Walk(node, func(n *Node) {
+		if n.Type == TypeBinary {
+			stats.Operations[n.Token]++
+			stats.Tasks++
+		}
+	})
+
+	return stats
+}
+
+func Walk(node *Node, fn func(*Node)) {
+	if node == nil {
+		return
+	}
+	Walk(node.Left, fn)
+	Walk(node.Right, fn)
+	Walk(node.Operand, fn)
+	fn(node)
+}
+
+func depth(node *Node) int {
+	if node == nil {
+		return 0
+	}
+	return 1 + max(depth(node.Left), depth(node.Right), depth(node.Operand))
+}
+
+func criticalPath(node *Node) (int, int) {
+	if node == nil {
+		return 0, 0
+	}
+
+	switch node.Type {
+	case TypeUnary:
+		return criticalPath(node.Operand)
+	case TypeBinary:
+		leftTasks, leftMS := criticalPath(node.Left)
+		rightTasks, rightMS := criticalPath(node.Right)
+		if rightMS > leftMS || (rightMS == leftMS && rightTasks > leftTasks) {
+			leftTasks, leftMS = rightTasks, rightMS
+		}
+		return leftTasks + 1, leftMS + timings.OperationTime(node.Token)
+	default:
+		return 0, 0
+	}
+}
+
+// Estimate simulates list scheduling of the task graph on the given number of
+// agents, always starting the ready task with the longest remaining path.
+func Estimate(node *Node, agents int) int {
+	if agents < 1 {
+		agents = 1
+	}
+
+	var tasks []*estimateTask
+	var build func(n *Node, parent *estimateTask)
+	build = func(n *Node, parent *estimateTask) {
+		if n == nil {
+			return
+		}
+		switch n.Type {
+		case TypeUnary:
+			build(n.Operand, parent)
+		case TypeBinary:
+			task := &estimateTask{cost: timings.OperationTime(n.Token), parent: parent}
+			if parent != nil {
+				parent.deps++
+			}
+			tasks = append(tasks, task)
+			build(n.Left, task)
+			build(n.Right, task)
+		}
+	}
+	build(node, nil)
+
+	for _, task := range tasks {
+		task.rank = task.cost
+		if task.parent != nil {
+			task.rank += task.parent.rank
+		}
+	}
+
+	var ready, running []*estimateTask
+	for _, task := range tasks {
+		if task.deps == 0 {
+			ready = append(ready, task)
+		}
+	}
+
+	now := 0
+	for len(ready) > 0 || len(running) > 0 {
+		sort.SliceStable(ready, func(i, j int) bool {
+			return ready[i].rank > ready[j].rank
+		})
+		for len(running) < agents && len(ready) > 0 {
+			task := ready[0]
+			ready = ready[1:]
+			task.finishing = now + task.cost
+			running = append(running, task)
+		}
+
+		sort.Slice(running, func(i, j int) bool {
+			return running[i].finishing < running[j].finishing
+		})
+		task := running[0]
+		running = running[1:]
+		now = task.finishing
+
+		if task.parent != nil {
+			task.parent.deps--
+			if task.parent.deps == 0 {
+				ready = append(ready, task.parent)
+			}
+		}
+	}
+
+	return now
+}
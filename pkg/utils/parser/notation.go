@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	NotationInfix  = "infix"
+	NotationRPN    = "rpn"
+	NotationPrefix = "prefix"
+
+	// Negation in postfix and prefix input, where "-" is always binary.
+	tokenNeg = "neg"
+)
+
+func ParseNotation(expression, notation string) (*Node, error) {
+	return ParseNotationDepth(expression, notation, 0)
+}
+
+// ParseNotationDepth is ParseNotation that rejects input nested deeper than
+// maxDepth levels (0 means MaxNesting). In postfix and prefix input every
+// operator is a level of its own.
+func ParseNotationDepth(expression, notation string, maxDepth int) (*Node, error) {
+	switch notation {
+	case "", NotationInfix:
+		return ParseDepth(expression, maxDepth)
+	case NotationRPN:
+		return parseRPN(expression, nestingLimit(maxDepth))
+	case NotationPrefix:
+		return parsePrefix(expression, nestingLimit(maxDepth))
+	default:
+		return nil, fmt.Errorf("%w: %q", errors.ErrInvalidNotation, notation)
+	}
+}
+
+// ParseRPN parses whitespace separated postfix notation, e.g. "10 2 + 2 *".
+func ParseRPN(expression string) (*Node, error) {
+	return parseRPN(expression, MaxNesting)
+}
+
+func parseRPN(expression string, maxDepth int) (*Node, error) {
+	tokens, err := splitTokens(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	// depths[i] is how deep stack[i] is nested.
+	var stack []*Node
+	var depths []int
+	for _, tok := range tokens {
+		switch {
+		case isOperator(tok.text):
+			if len(stack) < 2 {
+				return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+			}
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			depth := max(depths[len(depths)-2], depths[len(depths)-1]) + 1
+			if depth > maxDepth {
+				return nil, errors.NewExpressionError(errors.ErrExpressionTooDeep, tok.offset, tok.text)
+			}
+			stack = append(stack[:len(stack)-2], &Node{Type: TypeBinary, Token: tok.text, Offset: tok.offset, Left: left, Right: right})
+			depths = append(depths[:len(depths)-2], depth)
+		case tok.text == tokenNeg:
+			if len(stack) < 1 {
+				return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+			}
+			if depths[len(depths)-1]++; depths[len(depths)-1] > maxDepth {
+				return nil, errors.NewExpressionError(errors.ErrExpressionTooDeep, tok.offset, tok.text)
+			}
+			stack[len(stack)-1] = negate(stack[len(stack)-1], tok.offset)
+		default:
+			node, err := parseNumber(tok)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, node)
+			depths = append(depths, 0)
+		}
+	}
+
+	switch len(stack) {
+	case 0:
+		return nil, errors.NewExpressionError(errors.ErrEmptyExpression, 0, "")
+	case 1:
+		return stack[0], nil
+	default:
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedEnd, len(expression), "")
+	}
+}
+
+// ParsePrefix parses whitespace separated prefix notation, e.g. "* + 10 2 2".
+func ParsePrefix(expression string) (*Node, error) {
+	return parsePrefix(expression, MaxNesting)
+}
+
+func parsePrefix(expression string, maxDepth int) (*Node, error) {
+	tokens, err := splitTokens(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.NewExpressionError(errors.ErrEmptyExpression, 0, "")
+	}
+
+	p := &parser{tokens: tokens, end: len(expression), maxDepth: maxDepth}
+	node, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, ok := p.peek(); ok {
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+	}
+
+	return node, nil
+}
+
+func (p *parser) parsePrefix() (*Node, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedEnd, p.end, "")
+	}
+	p.pos++
+
+	switch {
+	case isOperator(tok.text):
+		if err := p.nest(tok); err != nil {
+			return nil, err
+		}
+		left, err := p.parsePrefix()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parsePrefix()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return &Node{Type: TypeBinary, Token: tok.text, Offset: tok.offset, Left: left, Right: right}, nil
+	case tok.text == tokenNeg:
+		if err := p.nest(tok); err != nil {
+			return nil, err
+		}
+		operand, err := p.parsePrefix()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return negate(operand, tok.offset), nil
+	default:
+		return parseNumber(tok)
+	}
+}
+
+func splitTokens(expression string) ([]token, error) {
+	var tokens []token
+
+	start := -1
+	for i := 0; i <= len(expression); i++ {
+		if i < len(expression) && !isSpace(expression[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{text: expression[start:i], offset: start})
+			start = -1
+		}
+	}
+
+	for _, tok := range tokens {
+		if tok.text == "(" || tok.text == ")" {
+			return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseNumber reads a single number token, which may carry a leading minus
+// since "-" alone is always an operator in postfix and prefix input.
+func parseNumber(tok token) (*Node, error) {
+	text := strings.TrimPrefix(tok.text, "-")
+	if text == "" || !(isDigit(text[0]) || text[0] == '.') {
+		return nil, errors.NewExpressionError(errors.ErrUnknownOperation, tok.offset, tok.text)
+	}
+
+	node, err := (&parser{tokens: []token{{text: text, offset: tok.offset}}}).parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if len(text) != len(tok.text) {
+		node = negate(node, tok.offset)
+	}
+	return node, nil
+}
+
+// negate mirrors the infix parser: a minus applied to a literal is folded into
+// the literal, so every notation yields the same tree.
+func negate(operand *Node, offset int) *Node {
+	if operand.Type == TypeNumber {
+		return &Node{Type: TypeNumber, Token: "-" + operand.Token, Offset: offset, Value: -operand.Value}
+	}
+	return &Node{Type: TypeUnary, Token: "-", Offset: offset, Operand: operand}
+}
+
+func isOperator(text string) bool {
+	return text == "+" || text == "-" || text == "*" || text == "/"
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
@@ -0,0 +1,251 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	TypeNumber = "number"
+	TypeUnary  = "unary"
+	TypeBinary = "binary"
+)
+
+type Node struct {
+	Type    string  `json:"type"`
+	Token   string  `json:"token"`
+	Offset  int     `json:"offset"`
+	Value   float64 `json:"-"`
+	Left    *Node   `json:"left,omitempty"`
+	Right   *Node   `json:"right,omitempty"`
+	Operand *Node   `json:"operand,omitempty"`
+}
+
+type token struct {
+	text   string
+	offset int
+}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expression); i++ {
+		ch := expression[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			continue
+		case isDigit(ch) || ch == '.':
+			start := i
+			for i < len(expression) && (isDigit(expression[i]) || expression[i] == '.') {
+				i++
+			}
+			if i < len(expression) && (expression[i] == 'e' || expression[i] == 'E') {
+				j := i + 1
+				if j < len(expression) && (expression[j] == '+' || expression[j] == '-') {
+					j++
+				}
+				if j < len(expression) && isDigit(expression[j]) {
+					for j < len(expression) && isDigit(expression[j]) {
+						j++
+					}
+					i = j
+				}
+			}
+			tokens = append(tokens, token{text: expression[start:i], offset: start})
+			i--
+		case ch == '+' || ch == '-' || ch == '*' || ch == '/' || ch == '(' || ch == ')':
+			tokens = append(tokens, token{text: string(ch), offset: i})
+		default:
+			return nil, errors.NewExpressionError(errors.ErrUnknownOperation, i, string(ch))
+		}
+	}
+
+	return tokens, nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// MaxNesting bounds the recursion of the parsers whatever limit the caller
+// asks for, so that no input can exhaust the stack.
+const MaxNesting = 10000
+
+type parser struct {
+	tokens   []token
+	pos      int
+	end      int
+	depth    int
+	maxDepth int
+}
+
+func nestingLimit(maxDepth int) int {
+	if maxDepth <= 0 || maxDepth > MaxNesting {
+		return MaxNesting
+	}
+	return maxDepth
+}
+
+// nest enters one more level of parentheses, negation or prefix operators;
+// the caller leaves it with p.depth--.
+func (p *parser) nest(tok token) error {
+	p.depth++
+	if p.depth > p.maxDepth {
+		return errors.NewExpressionError(errors.ErrExpressionTooDeep, tok.offset, tok.text)
+	}
+	return nil
+}
+
+func Parse(expression string) (*Node, error) {
+	return ParseDepth(expression, 0)
+}
+
+// ParseDepth is Parse that rejects input nested deeper than maxDepth levels
+// of parentheses and negations (0 means MaxNesting).
+func ParseDepth(expression string, maxDepth int) (*Node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.NewExpressionError(errors.ErrEmptyExpression, 0, "")
+	}
+
+	p := &parser{tokens: tokens, end: len(expression), maxDepth: nestingLimit(maxDepth)}
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, ok := p.peek(); ok {
+		if tok.text == ")" {
+			return nil, errors.NewExpressionError(errors.ErrMismatchedParentheses, tok.offset, tok.text)
+		}
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) parseExpression() (*Node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || (tok.text != "+" && tok.text != "-") {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Type: TypeBinary, Token: tok.text, Offset: tok.offset, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseTerm() (*Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+		if !ok || (tok.text != "*" && tok.text != "/") {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Type: TypeBinary, Token: tok.text, Offset: tok.offset, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseUnary() (*Node, error) {
+	tok, ok := p.peek()
+	if !ok || tok.text != "-" {
+		return p.parsePrimary()
+	}
+	p.pos++
+
+	if err := p.nest(tok); err != nil {
+		return nil, err
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	p.depth--
+
+	if operand.Type == TypeNumber {
+		return &Node{Type: TypeNumber, Token: "-" + operand.Token, Offset: tok.offset, Value: -operand.Value}, nil
+	}
+	return &Node{Type: TypeUnary, Token: tok.text, Offset: tok.offset, Operand: operand}, nil
+}
+
+func (p *parser) parsePrimary() (*Node, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedEnd, p.end, "")
+	}
+
+	switch {
+	case tok.text == "(":
+		p.pos++
+		if err := p.nest(tok); err != nil {
+			return nil, err
+		}
+		node, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		closing, ok := p.peek()
+		if !ok || closing.text != ")" {
+			return nil, errors.NewExpressionError(errors.ErrMismatchedParentheses, tok.offset, tok.text)
+		}
+		p.pos++
+		return node, nil
+	case isDigit(tok.text[0]) || tok.text[0] == '.':
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			if math.IsInf(value, 0) {
+				return nil, errors.NewExpressionError(errors.ErrOverflow, tok.offset, tok.text)
+			}
+		} else if err != nil {
+			return nil, errors.NewExpressionError(errors.ErrInvalidNumber, tok.offset, tok.text)
+		}
+		p.pos++
+		return &Node{Type: TypeNumber, Token: tok.text, Offset: tok.offset, Value: value}, nil
+	default:
+		return nil, errors.NewExpressionError(errors.ErrUnexpectedToken, tok.offset, tok.text)
+	}
+}
+
+func Check(node *Node) error {
+	var err error
+	Walk(node, func(n *Node) {
+		if err == nil && n.Type == TypeBinary && n.Token == "/" && n.Right.Type == TypeNumber && n.Right.Value == 0 {
+			err = errors.NewExpressionError(errors.ErrDivisionByZero, n.Offset, n.Token)
+		}
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+func Canonical(node *Node) string {
+	var sb strings.Builder
+	printInfix(&sb, node)
+	return sb.String()
+}
+
+// Substitute returns a copy of the tree where every node found in values is
+// replaced by a number literal.
+func Substitute(node *Node, values map[*Node]float64) *Node {
+	if value, ok := values[node]; ok {
+		return &Node{Type: TypeNumber, Token: FormatNumber(value), Offset: node.Offset, Value: value}
+	}
+
+	switch node.Type {
+	case TypeUnary:
+		copied := *node
+		copied.Operand = Substitute(node.Operand, values)
+		return &copied
+	case TypeBinary:
+		copied := *node
+		copied.Left = Substitute(node.Left, values)
+		copied.Right = Substitute(node.Right, values)
+		return &copied
+	default:
+		return node
+	}
+}
+
+func ToRPN(node *Node) string {
+	var parts []string
+	Walk(node, func(n *Node) {
+		parts = append(parts, postfixToken(n))
+	})
+	return strings.Join(parts, " ")
+}
+
+func ToPrefix(node *Node) string {
+	var parts []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		parts = append(parts, postfixToken(n))
+		switch n.Type {
+		case TypeUnary:
+			walk(n.Operand)
+		case TypeBinary:
+			walk(n.Left)
+			walk(n.Right)
+		}
+	}
+	walk(node)
+	return strings.Join(parts, " ")
+}
+
+func postfixToken(node *Node) string {
+	switch node.Type {
+	case TypeNumber:
+		return FormatNumber(node.Value)
+	case TypeUnary:
+		return tokenNeg
+	default:
+		return node.Token
+	}
+}
+
+func FormatNumber(value float64) string {
+	abs := math.Abs(value)
+	if abs >= 1e21 || (abs != 0 && abs < 1e-6) {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func Precedence(node *Node) int {
+	switch node.Type {
+	case TypeBinary:
+		if node.Token == "*" || node.Token == "/" {
+			return 2
+		}
+		return 1
+	case TypeUnary:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// NeedsParens reports whether child has to be bracketed under parent so that
+// printing and parsing again yields the same tree. Operators are parsed left
+// associative, so an equal-precedence right operand keeps its brackets.
+func NeedsParens(parent, child *Node, right bool) bool {
+	if parent.Type == TypeUnary {
+		return child.Type == TypeBinary || (child.Type == TypeNumber && math.Signbit(child.Value))
+	}
+	if right {
+		return Precedence(child) <= Precedence(parent)
+	}
+	return Precedence(child) < Precedence(parent)
+}
+
+func printInfix(sb *strings.Builder, node *Node) {
+	switch node.Type {
+	case TypeNumber:
+		sb.WriteString(FormatNumber(node.Value))
+	case TypeUnary:
+		sb.WriteString(node.Token)
+		printOperand(sb, node, node.Operand, false)
+	case TypeBinary:
+		printOperand(sb, node, node.Left, false)
+		sb.WriteString(" " + node.Token + " ")
+		printOperand(sb, node, node.Right, true)
+	}
+}
+
+func printOperand(sb *strings.Builder, parent, child *Node, right bool) {
+	if NeedsParens(parent, child, right) {
+		sb.WriteString("(")
+		printInfix(sb, child)
+		sb.WriteString(")")
+		return
+	}
+	printInfix(sb, child)
+}
@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/xKARASb/Calculator/pkg/utils/errors"
+)
+
+const (
+	RenderLaTeX  = "latex"
+	RenderMathML = "mathml"
+)
+
+// Render typesets the tree and, when result is set, appends "= result".
+func Render(node *Node, mode string, result *float64) (string, error) {
+	var r renderer
+	switch mode {
+	case RenderLaTeX:
+		r = latex{}
+	case RenderMathML:
+		r = mathml{}
+	default:
+		return "", fmt.Errorf("%w: %q", errors.ErrInvalidRender, mode)
+	}
+
+	var sb strings.Builder
+	renderNode(&sb, r, node)
+	if result != nil {
+		sb.WriteString(r.operator("="))
+		sb.WriteString(r.number(*result))
+	}
+	return r.wrap(sb.String()), nil
+}
+
+type renderer interface {
+	number(value float64) string
+	operator(op string) string
+	minus() string
+	fraction(num, den string) string
+	parens(inner string) string
+	wrap(body string) string
+}
+
+func renderNode(sb *strings.Builder, r renderer, node *Node) {
+	switch node.Type {
+	case TypeNumber:
+		sb.WriteString(r.number(node.Value))
+	case TypeUnary:
+		sb.WriteString(r.minus())
+		renderOperand(sb, r, node, node.Operand, false)
+	case TypeBinary:
+		if node.Token == "/" {
+			sb.WriteString(r.fraction(renderString(r, node.Left), renderString(r, node.Right)))
+			return
+		}
+		renderOperand(sb, r, node, node.Left, false)
+		sb.WriteString(r.operator(node.Token))
+		renderOperand(sb, r, node, node.Right, true)
+	}
+}
+
+func renderString(r renderer, node *Node) string {
+	var sb strings.Builder
+	renderNode(&sb, r, node)
+	return sb.String()
+}
+
+func renderOperand(sb *strings.Builder, r renderer, parent, child *Node, right bool) {
+	if renderParens(parent, child, right) {
+		sb.WriteString(r.parens(renderString(r, child)))
+		return
+	}
+	renderNode(sb, r, child)
+}
+
+// renderParens differs from NeedsParens in two places: a fraction groups its
+// own operands, and a negative operand after an operator is bracketed for
+// readability, as in 2 - (-3).
+func renderParens(parent, child *Node, right bool) bool {
+	if child.Type == TypeBinary && child.Token == "/" {
+		return false
+	}
+	if negative(child) && (right || parent.Type == TypeUnary) {
+		return true
+	}
+	return NeedsParens(parent, child, right)
+}
+
+func negative(node *Node) bool {
+	return node.Type == TypeUnary || (node.Type == TypeNumber && math.Signbit(node.Value))
+}
+
+func splitNumber(value float64) (string, string) {
+	mantissa, exponent, _ := strings.Cut(FormatNumber(value), "e")
+	return mantissa, strings.TrimPrefix(exponent, "+")
+}
+
+type latex struct{}
+
+func (latex) number(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return `\mathrm{NaN}`
+	case math.IsInf(value, 1):
+		return `\infty`
+	case math.IsInf(value, -1):
+		return `-\infty`
+	}
+
+	mantissa, exponent := splitNumber(value)
+	if exponent == "" {
+		return mantissa
+	}
+	return mantissa + ` \times 10^{` + exponent + `}`
+}
+
+func (latex) operator(op string) string {
+	if op == "*" {
+		return ` \cdot `
+	}
+	return " " + op + " "
+}
+
+func (latex) minus() string {
+	return "-"
+}
+
+func (latex) fraction(num, den string) string {
+	return `\frac{` + num + `}{` + den + `}`
+}
+
+func (latex) parens(inner string) string {
+	return `\left(` + inner + `\right)`
+}
+
+func (latex) wrap(body string) string {
+	return body
+}
+
+type mathml struct{}
+
+func (mathml) number(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return `<mi>NaN</mi>`
+	case math.IsInf(value, 1):
+		return `<mi>&#x221E;</mi>`
+	case math.IsInf(value, -1):
+		return `<mrow><mo>-</mo><mi>&#x221E;</mi></mrow>`
+	}
+
+	mantissa, exponent := splitNumber(value)
+	sign := ""
+	if strings.HasPrefix(mantissa, "-") {
+		sign = `<mo>-</mo>`
+		mantissa = mantissa[1:]
+	}
+
+	number := `<mn>` + mantissa + `</mn>`
+	if exponent != "" {
+		number += `<mo>&#xD7;</mo><msup><mn>10</mn><mn>` + exponent + `</mn></msup>`
+	}
+	if sign == "" && exponent == "" {
+		return number
+	}
+	return `<mrow>` + sign + number + `</mrow>`
+}
+
+func (mathml) operator(op string) string {
+	if op == "*" {
+		return `<mo>&#x22C5;</mo>`
+	}
+	return `<mo>` + op + `</mo>`
+}
+
+func (mathml) minus() string {
+	return `<mo>-</mo>`
+}
+
+func (mathml) fraction(num, den string) string {
+	return `<mfrac><mrow>` + num + `</mrow><mrow>` + den + `</mrow></mfrac>`
+}
+
+func (mathml) parens(inner string) string {
+	return `<mrow><mo>(</mo>` + inner + `<mo>)</mo></mrow>`
+}
+
+func (mathml) wrap(body string) string {
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>` + body + `</mrow></math>`
+}
@@ -0,0 +1,18 @@
+package precisions
+
+var (
+	PrecisionSingle = "single"
+	PrecisionDouble = "double"
+)
+
+func Valid(precision string) bool {
+	return precision == PrecisionSingle || precision == PrecisionDouble
+}
+
+// Of returns the precision a task runs in; tasks without one are double.
+func Of(precision string) string {
+	if precision == "" {
+		return PrecisionDouble
+	}
+	return precision
+}
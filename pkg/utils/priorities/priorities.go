@@ -0,0 +1,32 @@
+package priorities
+
+var (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// Weight is the share of agent time a priority level gets relative to the
+// others when they compete in the queue.
+func Weight(priority string) int {
+	switch priority {
+	case PriorityLow:
+		return 1
+	case PriorityHigh:
+		return 4
+	default:
+		return 2
+	}
+}
+
+func Valid(priority string) bool {
+	return priority == PriorityLow || priority == PriorityNormal || priority == PriorityHigh
+}
+
+// Clamp lowers priority to limit when it is above it.
+func Clamp(priority, limit string) string {
+	if Weight(priority) > Weight(limit) {
+		return limit
+	}
+	return priority
+}
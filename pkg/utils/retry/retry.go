@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	Jitter      float64
+}
+
+// Delay returns the wait before the given retry (1 for the first one): the
+// backoff doubles every attempt and is spread by +-Jitter of itself.
+func (p Policy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(p.Backoff) * float64(uint64(1)<<min(attempt-1, 30))
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Attempts is the total number of tries the policy allows, at least one.
+func (p Policy) Attempts() int {
+	return max(p.MaxAttempts, 1)
+}
+
+// Parse reads a policy written as "attempts/backoff/jitter", e.g. "5/200ms/0.2".
+func Parse(value string) (Policy, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 3 {
+		return Policy{}, fmt.Errorf("retry policy %q: want attempts/backoff/jitter", value)
+	}
+
+	attempts, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Policy{}, fmt.Errorf("retry policy %q: %w", value, err)
+	}
+	backoff, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return Policy{}, fmt.Errorf("retry policy %q: %w", value, err)
+	}
+	jitter, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return Policy{}, fmt.Errorf("retry policy %q: %w", value, err)
+	}
+
+	return Policy{MaxAttempts: attempts, Backoff: backoff, Jitter: jitter}, nil
+}
+
+type Policies struct {
+	Default     Policy
+	ByOperation map[string]Policy
+}
+
+func (p Policies) For(operation string) Policy {
+	if policy, ok := p.ByOperation[operation]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// ParsePolicies builds per operation policies from "operation: policy" pairs.
+func ParsePolicies(def Policy, values map[string]string) (Policies, error) {
+	policies := Policies{Default: def, ByOperation: make(map[string]Policy, len(values))}
+	for operation, value := range values {
+		policy, err := Parse(value)
+		if err != nil {
+			return Policies{}, err
+		}
+		policies.ByOperation[operation] = policy
+	}
+	return policies, nil
+}
@@ -0,0 +1,6 @@
+package roles
+
+var (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
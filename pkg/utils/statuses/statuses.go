@@ -1,8 +1,16 @@
 package statuses
 
 var (
-	StatusPending  = "pending"
-	StatusProgress = "in_progress"
-	StatusComplete = "complete"
-	StatusError    = "error"
+	StatusPending   = "pending"
+	StatusProgress  = "in_progress"
+	StatusComplete  = "complete"
+	StatusError     = "error"
+	StatusCancelled = "cancelled"
+	StatusTimeout   = "timeout"
+)
+
+// Statuses of a schedule.
+var (
+	StatusActive   = "active"
+	StatusFinished = "finished"
 )
@@ -6,3 +6,18 @@ var (
 	TimeMultiplicationMS = 15
 	TimeDivisionMS       = 15
 )
+
+func OperationTime(operation string) int {
+	switch operation {
+	case "+":
+		return TimeAdditionMS
+	case "-":
+		return TimeSubtractionMS
+	case "*":
+		return TimeMultiplicationMS
+	case "/":
+		return TimeDivisionMS
+	default:
+		return 0
+	}
+}